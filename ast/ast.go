@@ -0,0 +1,220 @@
+package ast
+
+import "fmt"
+
+// Pos is a position in the source. Lines and columns start at 1, columns
+// count runes and Offset counts bytes from the start of the file
+type Pos struct {
+	File   string
+	Line   int
+	Column int
+	Offset int
+}
+
+func (p Pos) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// Span is the text between two positions. End is right after the last
+// character
+type Span struct {
+	Start Pos
+	End   Pos
+}
+
+// Node is every element of the tree
+type Node interface {
+	Pos() Pos
+	Span() Span
+}
+
+// Statement is a node that doesn't produce a value
+type Statement interface {
+	Node
+	statement()
+}
+
+// Expression is a node that produces a value. Its type is unknown until the
+// tree is type checked
+type Expression interface {
+	Node
+	Type() Type
+	SetType(t Type)
+	expression()
+}
+
+// typed keeps the type the checker found for an expression
+type typed struct {
+	t Type
+}
+
+func (e *typed) Type() Type {
+	return e.t
+}
+
+func (e *typed) SetType(t Type) {
+	e.t = t
+}
+
+// Program is a whole source file, or a single REPL input
+type Program struct {
+	Statements []Statement
+	Eof        Pos
+}
+
+// ProcedureDecl is a DEFINE PROCEDURE "NAME" [PARAMS] ... END PROCEDURE block
+type ProcedureDecl struct {
+	Position Pos
+	Name     string
+	NameSpan Span
+	Params   []string
+	Body     []Statement
+	// EndProcedure is the position of the END PROCEDURE closing the body
+	EndProcedure Pos
+	End          Pos
+}
+
+// Assign stores a value into a variable or a cell
+type Assign struct {
+	Target Expression
+	Value  Expression
+}
+
+// If is an IF ... THEN ... END IF block. ELSE IF chains are nested ifs in the
+// Else branch
+type If struct {
+	Position  Pos
+	Condition Expression
+	Then      []Statement
+	Else      []Statement
+	// ThenEnd is the position of the ELSE or END IF closing the Then branch
+	ThenEnd Pos
+	End     Pos
+}
+
+// Loop is a LOOP ... TIMES ... END LOOP block
+type Loop struct {
+	Position Pos
+	Count    Expression
+	Body     []Statement
+	// EndLoop is the position of the END LOOP closing the body
+	EndLoop Pos
+	End     Pos
+}
+
+// Abort is an ABORT LOOP statement
+type Abort struct {
+	Position Pos
+	End      Pos
+}
+
+// Quit is a QUIT PROCEDURE statement
+type Quit struct {
+	Position Pos
+	End      Pos
+}
+
+// Call is a call to a procedure
+type Call struct {
+	typed
+	Position Pos
+	Name     string
+	Args     []Expression
+	End      Pos
+}
+
+// Binary is an operation between two expressions
+type Binary struct {
+	typed
+	Operator Operator
+	OpPos    Pos
+	Left     Expression
+	Right    Expression
+}
+
+// Unary is an operation over a single expression
+type Unary struct {
+	typed
+	Operator Operator
+	OpPos    Pos
+	Operand  Expression
+}
+
+// Grouping is an expression between parentheses
+type Grouping struct {
+	typed
+	Position   Pos
+	Expression Expression
+	End        Pos
+}
+
+// Literal is a number or a YES or NO. Numbers are int64 values unless they
+// don't fit in one, in which case they are *big.Int values
+type Literal struct {
+	typed
+	Position Pos
+	Value    interface{}
+	End      Pos
+}
+
+// VarRef is a reference to a variable
+type VarRef struct {
+	typed
+	Position Pos
+	Name     string
+	End      Pos
+}
+
+// CellRef is a reference to CELL(Index)
+type CellRef struct {
+	typed
+	Position Pos
+	Index    Expression
+	End      Pos
+}
+
+func (n *ProcedureDecl) Pos() Pos { return n.Position }
+func (n *Assign) Pos() Pos        { return n.Target.Pos() }
+func (n *If) Pos() Pos            { return n.Position }
+func (n *Loop) Pos() Pos          { return n.Position }
+func (n *Abort) Pos() Pos         { return n.Position }
+func (n *Quit) Pos() Pos          { return n.Position }
+func (n *Call) Pos() Pos          { return n.Position }
+func (n *Binary) Pos() Pos        { return n.Left.Pos() }
+func (n *Unary) Pos() Pos         { return n.OpPos }
+func (n *Grouping) Pos() Pos      { return n.Position }
+func (n *Literal) Pos() Pos       { return n.Position }
+func (n *VarRef) Pos() Pos        { return n.Position }
+func (n *CellRef) Pos() Pos       { return n.Position }
+
+func (n *ProcedureDecl) Span() Span { return Span{n.Position, n.End} }
+func (n *Assign) Span() Span        { return Span{n.Target.Pos(), n.Value.Span().End} }
+func (n *If) Span() Span            { return Span{n.Position, n.End} }
+func (n *Loop) Span() Span          { return Span{n.Position, n.End} }
+func (n *Abort) Span() Span         { return Span{n.Position, n.End} }
+func (n *Quit) Span() Span          { return Span{n.Position, n.End} }
+func (n *Call) Span() Span          { return Span{n.Position, n.End} }
+func (n *Binary) Span() Span        { return Span{n.Left.Pos(), n.Right.Span().End} }
+func (n *Unary) Span() Span         { return Span{n.OpPos, n.Operand.Span().End} }
+func (n *Grouping) Span() Span      { return Span{n.Position, n.End} }
+func (n *Literal) Span() Span       { return Span{n.Position, n.End} }
+func (n *VarRef) Span() Span        { return Span{n.Position, n.End} }
+func (n *CellRef) Span() Span       { return Span{n.Position, n.End} }
+
+func (*ProcedureDecl) statement() {}
+func (*Assign) statement()        {}
+func (*If) statement()            {}
+func (*Loop) statement()          {}
+func (*Abort) statement()         {}
+func (*Quit) statement()          {}
+
+func (*Call) expression()     {}
+func (*Binary) expression()   {}
+func (*Unary) expression()    {}
+func (*Grouping) expression() {}
+func (*Literal) expression()  {}
+func (*VarRef) expression()   {}
+func (*CellRef) expression()  {}
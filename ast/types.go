@@ -0,0 +1,64 @@
+package ast
+
+// Type is the static type of an expression
+type Type uint8
+
+const (
+	Number Type = iota
+	Boolean
+)
+
+func (t Type) String() string {
+	switch t {
+	case Number:
+		return "number"
+	case Boolean:
+		return "boolean"
+	default:
+		// Unreachable
+		return ""
+	}
+}
+
+// Operator is the operation of a Binary or Unary node
+type Operator uint8
+
+const (
+	Add Operator = iota
+	Multiply
+	Equal
+	Lesser
+	LesserEqual
+	Greater
+	GreaterEqual
+	And
+	Or
+	Not
+)
+
+var operators = map[Operator]string{
+	Add:          "+",
+	Multiply:     "*",
+	Equal:        "=",
+	Lesser:       "<",
+	LesserEqual:  "<=",
+	Greater:      ">",
+	GreaterEqual: ">=",
+	And:          "AND",
+	Or:           "OR",
+	Not:          "NOT",
+}
+
+func (o Operator) String() string {
+	return operators[o]
+}
+
+// Arithmetic reports whether the operator takes numbers and produces a number
+func (o Operator) Arithmetic() bool {
+	return o == Add || o == Multiply
+}
+
+// Logical reports whether the operator takes booleans and produces a boolean
+func (o Operator) Logical() bool {
+	return o == And || o == Or || o == Not
+}
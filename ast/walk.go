@@ -0,0 +1,48 @@
+package ast
+
+// Inspect visits the tree in depth first order, calling f for every node.
+// The children of a node are skipped when f returns false for it
+func Inspect(node Node, f func(Node) bool) {
+	if !f(node) {
+		return
+	}
+
+	switch n := node.(type) {
+	case *ProcedureDecl:
+		inspectStatements(n.Body, f)
+	case *Assign:
+		Inspect(n.Target, f)
+		Inspect(n.Value, f)
+	case *If:
+		Inspect(n.Condition, f)
+		inspectStatements(n.Then, f)
+		inspectStatements(n.Else, f)
+	case *Loop:
+		Inspect(n.Count, f)
+		inspectStatements(n.Body, f)
+	case *Call:
+		for _, arg := range n.Args {
+			Inspect(arg, f)
+		}
+	case *Binary:
+		Inspect(n.Left, f)
+		Inspect(n.Right, f)
+	case *Unary:
+		Inspect(n.Operand, f)
+	case *Grouping:
+		Inspect(n.Expression, f)
+	case *CellRef:
+		Inspect(n.Index, f)
+	}
+}
+
+// InspectProgram calls Inspect for every top level statement of the program
+func InspectProgram(p *Program, f func(Node) bool) {
+	inspectStatements(p.Statements, f)
+}
+
+func inspectStatements(statements []Statement, f func(Node) bool) {
+	for _, s := range statements {
+		Inspect(s, f)
+	}
+}
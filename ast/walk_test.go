@@ -0,0 +1,66 @@
+package ast_test
+
+import (
+	"github.com/gonzispina/gloop/ast"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	// IF CELL(N) = 1 THEN N <- N + 1 END IF
+	program := &ast.Program{
+		Statements: []ast.Statement{
+			&ast.If{
+				Condition: &ast.Binary{
+					Operator: ast.Equal,
+					Left:     &ast.CellRef{Index: &ast.VarRef{Name: "N"}},
+					Right:    &ast.Literal{Value: int64(1)},
+				},
+				Then: []ast.Statement{
+					&ast.Assign{
+						Target: &ast.VarRef{Name: "N"},
+						Value: &ast.Binary{
+							Operator: ast.Add,
+							Left:     &ast.VarRef{Name: "N"},
+							Right:    &ast.Literal{Value: int64(1)},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	t.Run("It visits every node in depth first order", func(t *testing.T) {
+		var visited []string
+		ast.InspectProgram(program, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.If:
+				visited = append(visited, "if")
+			case *ast.Assign:
+				visited = append(visited, "<-")
+			case *ast.Binary:
+				visited = append(visited, n.Operator.String())
+			case *ast.CellRef:
+				visited = append(visited, "cell")
+			case *ast.VarRef:
+				visited = append(visited, n.Name)
+			case *ast.Literal:
+				visited = append(visited, "1")
+			}
+			return true
+		})
+
+		assert.Equal(t, []string{"if", "=", "cell", "N", "1", "<-", "N", "+", "N", "1"}, visited)
+	})
+
+	t.Run("It skips the children of nodes when told to", func(t *testing.T) {
+		count := 0
+		ast.InspectProgram(program, func(n ast.Node) bool {
+			count++
+			_, isBinary := n.(*ast.Binary)
+			return !isBinary
+		})
+
+		assert.Equal(t, 5, count)
+	})
+}
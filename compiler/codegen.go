@@ -0,0 +1,437 @@
+package compiler
+
+import (
+	"github.com/gonzispina/gloop/ast"
+	"github.com/gonzispina/gloop/vm"
+	"math/big"
+)
+
+// generator walks a type checked tree emitting its bytecode
+type generator struct {
+	c      *Compiler
+	script vm.Chunk
+	chunk  *vm.Chunk
+	slots  map[string]int
+	loops  []*loop
+
+	// blockTooLarge is set when a jump didn't fit in 16 bits
+	blockTooLarge bool
+}
+
+type loop struct {
+	aborts []int
+}
+
+// generate emits the code of the tree on top of base. Jumps take two bytes
+// unless a block is too large for them, in which case the code is generated
+// again with long jumps
+func (c *Compiler) generate(base vm.Chunk, emit func(g *generator) []error) (vm.Chunk, []error) {
+	g := c.generator(base)
+	errs := emit(g)
+	if g.blockTooLarge && !c.longJumps {
+		c.longJumps = true
+		g = c.generator(base)
+		errs = emit(g)
+	}
+
+	if len(errs) != 0 {
+		return vm.Chunk{}, errs
+	}
+
+	c.slots = g.slots
+	return g.script, nil
+}
+
+func (c *Compiler) generator(base vm.Chunk) *generator {
+	g := &generator{c: c, script: base, slots: map[string]int{}}
+	if c.longJumps {
+		g.script.UseLongJumps()
+	}
+	g.chunk = &g.script
+
+	for name, slot := range c.slots {
+		g.slots[name] = slot
+	}
+
+	// Procedures seen for the first time get their function now, in the
+	// order the checker gave them
+	for _, p := range c.procedureTable() {
+		if p.index < len(g.script.Procedures()) {
+			continue
+		}
+
+		p.fn = &vm.Function{Name: p.name, Arity: len(p.params), Chunk: c.newChunk()}
+		p.fn.Chunk.SetOutput(kind(p.output))
+		g.script.AddProcedure(p.fn)
+	}
+
+	return g
+}
+
+// program emits the code of every statement followed by the return of the
+// script
+func (g *generator) program(program *ast.Program) []error {
+	var errs []error
+	for _, s := range program.Statements {
+		if err := g.statement(s); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) != 0 {
+		return errs
+	}
+
+	if err := g.emitReturn(program.Eof); err != nil {
+		return []error{err}
+	}
+
+	if v, ok := g.c.vars[outputName]; ok {
+		g.script.SetOutput(kind(v.vt))
+	}
+	return nil
+}
+
+// bareExpression emits an expression followed by a return of its value
+func (g *generator) bareExpression(e ast.Expression, end ast.Pos) []error {
+	if err := g.expression(e); err != nil {
+		return []error{err}
+	}
+
+	g.chunk.Append(vm.OpReturn.Byte(), end.Line)
+	g.script.SetOutput(kind(e.Type()))
+	return nil
+}
+
+func (g *generator) emitConstant(pos ast.Pos, v vm.Value) error {
+	if err := g.chunk.EmitConstant(v, pos.Line); err != nil {
+		return tooManyConstantsErr(at(pos))
+	}
+	return nil
+}
+
+func (g *generator) emitLocal(pos ast.Pos, code vm.OpCode, slot int) error {
+	if err := g.chunk.EmitLocal(code, slot, pos.Line); err != nil {
+		return tooManyLocalsErr(at(pos))
+	}
+	return nil
+}
+
+func (g *generator) patchJump(pos ast.Pos, offset int) error {
+	if err := g.chunk.PatchJump(offset); err != nil {
+		g.blockTooLarge = true
+		return blockIsTooLargeErr(at(pos))
+	}
+	return nil
+}
+
+func (g *generator) emitLoop(pos ast.Pos, start int) error {
+	if err := g.chunk.EmitLoop(start, pos.Line); err != nil {
+		g.blockTooLarge = true
+		return blockIsTooLargeErr(at(pos))
+	}
+	return nil
+}
+
+func (g *generator) declareVariable(name string) int {
+	slot := g.chunk.AddLocal()
+	g.slots[name] = slot
+	return slot
+}
+
+func (g *generator) emitReturn(pos ast.Pos) error {
+	if slot, ok := g.slots[outputName]; ok {
+		if err := g.emitLocal(pos, vm.OpGet, slot); err != nil {
+			return err
+		}
+	} else {
+		g.chunk.Append(vm.OpPush.Byte(), pos.Line, 0)
+	}
+	g.chunk.Append(vm.OpReturn.Byte(), pos.Line)
+	return nil
+}
+
+func (g *generator) statements(statements []ast.Statement) error {
+	for _, s := range statements {
+		if err := g.statement(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *generator) statement(s ast.Statement) error {
+	switch n := s.(type) {
+	case *ast.Assign:
+		return g.assign(n)
+	case *ast.If:
+		return g.ifStatement(n)
+	case *ast.Loop:
+		return g.loop(n)
+	case *ast.Abort:
+		l := g.loops[len(g.loops)-1]
+		l.aborts = append(l.aborts, g.chunk.EmitJump(vm.OpJump, n.Pos().Line))
+	case *ast.Quit:
+		return g.emitReturn(n.Pos())
+	case *ast.ProcedureDecl:
+		return g.procedureDeclaration(n)
+	}
+	return nil
+}
+
+func (g *generator) assign(n *ast.Assign) error {
+	if cell, ok := n.Target.(*ast.CellRef); ok {
+		if err := g.expression(cell.Index); err != nil {
+			return err
+		}
+
+		if err := g.expression(n.Value); err != nil {
+			return err
+		}
+
+		g.chunk.Append(vm.OpSetCell.Byte(), cell.Pos().Line)
+		return nil
+	}
+
+	// Variables get their slot on their first assignment, before the value
+	// is evaluated
+	target := n.Target.(*ast.VarRef)
+	slot, ok := g.slots[target.Name]
+	if !ok {
+		slot = g.declareVariable(target.Name)
+	}
+
+	if err := g.expression(n.Value); err != nil {
+		return err
+	}
+
+	return g.emitLocal(target.Pos(), vm.OpSet, slot)
+}
+
+func (g *generator) ifStatement(n *ast.If) error {
+	line := n.Condition.Pos().Line
+	if err := g.expression(n.Condition); err != nil {
+		return err
+	}
+
+	thenJump := g.chunk.EmitJump(vm.OpJumpIfFalse, line)
+	g.chunk.Append(vm.OpPop.Byte(), line)
+	if err := g.statements(n.Then); err != nil {
+		return err
+	}
+
+	endJump := g.chunk.EmitJump(vm.OpJump, n.ThenEnd.Line)
+	if err := g.patchJump(n.ThenEnd, thenJump); err != nil {
+		return err
+	}
+	g.chunk.Append(vm.OpPop.Byte(), n.ThenEnd.Line)
+
+	if err := g.statements(n.Else); err != nil {
+		return err
+	}
+
+	return g.patchJump(n.ThenEnd, endJump)
+}
+
+// loop evaluates the amount of iterations once and stores it in a hidden
+// local next to a counter that goes up until it reaches it
+func (g *generator) loop(n *ast.Loop) error {
+	pos := n.Count.Pos()
+	if err := g.expression(n.Count); err != nil {
+		return err
+	}
+
+	limit, counter := g.chunk.AddLocal(), g.chunk.AddLocal()
+	if err := g.emitLocal(pos, vm.OpSet, limit); err != nil {
+		return err
+	}
+	g.chunk.Append(vm.OpPush.Byte(), pos.Line, 0)
+	if err := g.emitLocal(pos, vm.OpSet, counter); err != nil {
+		return err
+	}
+
+	loopStart := g.chunk.InstructionsCount()
+	if err := g.emitLocal(pos, vm.OpGet, counter); err != nil {
+		return err
+	}
+	if err := g.emitLocal(pos, vm.OpGet, limit); err != nil {
+		return err
+	}
+	g.chunk.Append(vm.OpLesser.Byte(), pos.Line)
+	exitJump := g.chunk.EmitJump(vm.OpJumpIfFalse, pos.Line)
+	g.chunk.Append(vm.OpPop.Byte(), pos.Line)
+
+	l := &loop{}
+	g.loops = append(g.loops, l)
+	err := g.statements(n.Body)
+	g.loops = g.loops[:len(g.loops)-1]
+	if err != nil {
+		return err
+	}
+
+	if err := g.emitLocal(n.EndLoop, vm.OpGet, counter); err != nil {
+		return err
+	}
+	g.chunk.Append(vm.OpPush.Byte(), n.EndLoop.Line, 1)
+	g.chunk.Append(vm.OpAdd.Byte(), n.EndLoop.Line)
+	if err := g.emitLocal(n.EndLoop, vm.OpSet, counter); err != nil {
+		return err
+	}
+	if err := g.emitLoop(n.EndLoop, loopStart); err != nil {
+		return err
+	}
+
+	if err := g.patchJump(n.EndLoop, exitJump); err != nil {
+		return err
+	}
+	g.chunk.Append(vm.OpPop.Byte(), n.EndLoop.Line)
+
+	for _, jump := range l.aborts {
+		if err := g.patchJump(n.EndLoop, jump); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g *generator) procedureDeclaration(n *ast.ProcedureDecl) error {
+	p := g.c.procedures[n.Name]
+
+	slots := g.slots
+	g.chunk, g.slots = &p.fn.Chunk, map[string]int{}
+	defer func() {
+		g.chunk, g.slots = &g.script, slots
+	}()
+
+	for _, param := range n.Params {
+		g.declareVariable(param)
+	}
+	g.declareVariable(outputName)
+
+	if err := g.statements(n.Body); err != nil {
+		return err
+	}
+
+	return g.emitReturn(n.EndProcedure)
+}
+
+func (g *generator) expression(e ast.Expression) error {
+	switch n := e.(type) {
+	case *ast.Literal:
+		return g.literal(n)
+	case *ast.Grouping:
+		return g.expression(n.Expression)
+	case *ast.VarRef:
+		return g.emitLocal(n.Pos(), vm.OpGet, g.slots[n.Name])
+	case *ast.CellRef:
+		if err := g.expression(n.Index); err != nil {
+			return err
+		}
+		g.chunk.Append(vm.OpGetCell.Byte(), n.Pos().Line)
+	case *ast.Call:
+		return g.call(n)
+	case *ast.Unary:
+		if err := g.expression(n.Operand); err != nil {
+			return err
+		}
+		g.chunk.Append(vm.OpNot.Byte(), n.OpPos.Line)
+	case *ast.Binary:
+		if n.Operator == ast.And || n.Operator == ast.Or {
+			return g.logical(n)
+		}
+		return g.binary(n)
+	}
+	return nil
+}
+
+func (g *generator) literal(n *ast.Literal) error {
+	line := n.Pos().Line
+	switch v := n.Value.(type) {
+	case int64:
+		if v <= 0xff {
+			g.chunk.Append(vm.OpPush.Byte(), line, byte(v))
+			return nil
+		}
+		return g.emitConstant(n.Pos(), vm.NewValue(uint64(v)))
+	case *big.Int:
+		return g.emitConstant(n.Pos(), vm.BigValue(v))
+	case bool:
+		if v {
+			g.chunk.Append(vm.OpPush.Byte(), line, 1)
+		} else {
+			g.chunk.Append(vm.OpPush.Byte(), line, 0)
+		}
+	}
+	return nil
+}
+
+func (g *generator) call(n *ast.Call) error {
+	for _, arg := range n.Args {
+		if err := g.expression(arg); err != nil {
+			return err
+		}
+	}
+
+	if err := g.chunk.EmitCall(g.c.procedures[n.Name].index, n.Pos().Line); err != nil {
+		return tooManyProceduresErr(n.Span())
+	}
+	return nil
+}
+
+func (g *generator) binary(n *ast.Binary) error {
+	if err := g.expression(n.Left); err != nil {
+		return err
+	}
+
+	if err := g.expression(n.Right); err != nil {
+		return err
+	}
+
+	line := n.OpPos.Line
+	switch n.Operator {
+	case ast.Add:
+		g.chunk.Append(vm.OpAdd.Byte(), line)
+	case ast.Multiply:
+		g.chunk.Append(vm.OpMultiply.Byte(), line)
+	case ast.Equal:
+		g.chunk.Append(vm.OpEqual.Byte(), line)
+	case ast.Greater:
+		g.chunk.Append(vm.OpGreater.Byte(), line)
+	case ast.Lesser:
+		g.chunk.Append(vm.OpLesser.Byte(), line)
+	case ast.GreaterEqual:
+		g.chunk.Append(vm.OpLesser.Byte(), line)
+		g.chunk.Append(vm.OpNot.Byte(), line)
+	case ast.LesserEqual:
+		g.chunk.Append(vm.OpGreater.Byte(), line)
+		g.chunk.Append(vm.OpNot.Byte(), line)
+	}
+	return nil
+}
+
+// logical skips the right operand of AND when the left one is false, and the
+// one of OR when the left one is true, leaving the left one on the stack as
+// the result
+func (g *generator) logical(n *ast.Binary) error {
+	if err := g.expression(n.Left); err != nil {
+		return err
+	}
+
+	line := n.OpPos.Line
+	endJump := g.chunk.EmitJump(vm.OpJumpIfFalse, line)
+	if n.Operator == ast.Or {
+		elseJump := endJump
+		endJump = g.chunk.EmitJump(vm.OpJump, line)
+		if err := g.patchJump(n.OpPos, elseJump); err != nil {
+			return err
+		}
+	}
+	g.chunk.Append(vm.OpPop.Byte(), line)
+
+	if err := g.expression(n.Right); err != nil {
+		return err
+	}
+
+	return g.patchJump(n.OpPos, endJump)
+}
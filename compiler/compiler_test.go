@@ -1,7 +1,13 @@
 package compiler_test
 
 import (
+	"fmt"
 	"github.com/gonzispina/gloop/compiler"
+	"github.com/gonzispina/gloop/vm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"strings"
 	"testing"
 )
 
@@ -12,7 +18,7 @@ func TestCompiler_Compile_Assignments(t *testing.T) {
 		`
 
 		_, errs := compile(t, text)
-		assertErrContains(t, errs, compiler.ExpectedAssignmentOperatorErrCode)
+		assertErrCode(t, errs, compiler.ExpectedAssignmentOperatorErrCode)
 	})
 
 	t.Run("Bad assignment type returns an invalid type error", func(t *testing.T) {
@@ -22,7 +28,7 @@ func TestCompiler_Compile_Assignments(t *testing.T) {
 		`
 
 		_, errs := compile(t, text)
-		assertErrContains(t, errs, compiler.InvalidTypeErrCode)
+		assertErrCode(t, errs, compiler.InvalidTypeErrCode)
 
 		text = `
 			OUTPUT <- 1
@@ -30,18 +36,36 @@ func TestCompiler_Compile_Assignments(t *testing.T) {
 		`
 
 		_, errs = compile(t, text)
-		assertErrContains(t, errs, compiler.InvalidTypeErrCode)
+		assertErrCode(t, errs, compiler.InvalidTypeErrCode)
 	})
 
-	t.Run("Uninitialized variable usage returns uninitialized variable error", func(t *testing.T) {
+	t.Run("Undeclared variable usage returns undefined variable error", func(t *testing.T) {
 		text := `
 			OUTPUT <- N
 		`
 
 		_, errs := compile(t, text)
-		assertErrContains(t, errs, compiler.UndefinedVariableErrCode)
+		assertErrCode(t, errs, compiler.UndefinedVariableErrCode)
 	})
 	
+	t.Run("Variable reads load the variable and keep its type", func(t *testing.T) {
+		text := `
+			N <- 41
+			B <- YES
+			OUTPUT <- N + 1
+		`
+
+		assert.Equal(t, vm.NewValue(42), run(t, text))
+
+		text = `
+			B <- YES
+			N <- B + 1
+		`
+
+		_, errs := compile(t, text)
+		assertErrCode(t, errs, compiler.NumberExpressionNeededCodeErr)
+	})
+
 	t.Run("Initialization of a variable with itself returns an uninitialized variable error", func(t *testing.T) {
 		text := `
 			N <- N + 1 
@@ -49,7 +73,769 @@ func TestCompiler_Compile_Assignments(t *testing.T) {
 		`
 
 		_, errs := compile(t, text)
-		assertErrContains(t, errs, compiler.UndefinedVariableErrCode)
+		assertErrCode(t, errs, compiler.UninitializedVariableErrCode)
+	})
+}
+
+func TestCompiler_Compile_Numbers(t *testing.T) {
+	t.Run("Literals bigger than a byte keep their value", func(t *testing.T) {
+		text := `
+			OUTPUT <- 1000 + 24
+		`
+
+		assert.Equal(t, vm.NewValue(1024), run(t, text))
+	})
+
+	t.Run("Literals bigger than 64 bits keep their value", func(t *testing.T) {
+		text := `
+			OUTPUT <- 1267650600228229401496703205376 + 1
+		`
+
+		assert.Equal(t, "1267650600228229401496703205377", run(t, text).String())
+	})
+
+	t.Run("Arithmetic does not overflow", func(t *testing.T) {
+		text := `
+			DEFINE PROCEDURE "SQUARE" [N]
+				OUTPUT <- N * N
+			END PROCEDURE
+			OUTPUT <- SQUARE[SQUARE[SQUARE[1024 * 1024 * 1024 * 1024 * 1024 * 1024 * 1024 * 1024 * 1024 * 1024]]]
+		`
+
+		expected := new(big.Int).Lsh(big.NewInt(1), 800)
+		assert.Equal(t, expected.String(), run(t, text).String())
+	})
+}
+
+func TestCompiler_Compile_Wide_Operands(t *testing.T) {
+	t.Run("Programs can use more than 256 constants and variables", func(t *testing.T) {
+		var text strings.Builder
+		for i := 0; i < 300; i++ {
+			fmt.Fprintf(&text, "N%v <- %v\n", i, 1000+i)
+		}
+		text.WriteString("OUTPUT <- N0 + N299\n")
+
+		assert.Equal(t, vm.NewValue(2299), run(t, text.String()))
+	})
+}
+
+func TestCompiler_Compile_Loops(t *testing.T) {
+	t.Run("The body runs as many times as the loop says", func(t *testing.T) {
+		text := `
+			OUTPUT <- 0
+			LOOP 3 TIMES
+				OUTPUT <- OUTPUT + 2
+			END LOOP
+		`
+
+		assert.Equal(t, vm.NewValue(6), run(t, text))
+	})
+
+	t.Run("The amount of iterations is evaluated only once", func(t *testing.T) {
+		text := `
+			N <- 3
+			LOOP N TIMES
+				N <- N + 1
+			END LOOP
+			OUTPUT <- N
+		`
+
+		assert.Equal(t, vm.NewValue(6), run(t, text))
+	})
+
+	t.Run("Looping zero times skips the body", func(t *testing.T) {
+		text := `
+			OUTPUT <- 1
+			LOOP 0 TIMES
+				OUTPUT <- 2
+			END LOOP
+		`
+
+		assert.Equal(t, vm.NewValue(1), run(t, text))
+	})
+
+	t.Run("Abort loop leaves the innermost loop from inside other blocks", func(t *testing.T) {
+		text := `
+			DEFINE PROCEDURE "MINUS" [M, N]
+				IF M < N THEN
+					QUIT PROCEDURE
+				END IF
+				LOOP M + 1 TIMES
+					IF OUTPUT + N = M THEN
+						ABORT LOOP
+					END IF
+					OUTPUT <- OUTPUT + 1
+				END LOOP
+			END PROCEDURE
+		`
+
+		assert.Equal(t, vm.NewValue(4), call(t, text, "MINUS", 7, 3))
+		assert.Equal(t, vm.NewValue(0), call(t, text, "MINUS", 2, 5))
+	})
+
+	t.Run("Loops compute big factorials exactly", func(t *testing.T) {
+		text := `
+			DEFINE PROCEDURE "FACTORIAL" [N]
+				OUTPUT <- 1
+				K <- 1
+				LOOP N TIMES
+					OUTPUT <- OUTPUT * K
+					K <- K + 1
+				END LOOP
+			END PROCEDURE
+		`
+
+		expected := big.NewInt(1)
+		for i := int64(2); i <= 30; i++ {
+			expected.Mul(expected, big.NewInt(i))
+		}
+		assert.Equal(t, expected.String(), call(t, text, "FACTORIAL", 30).String())
+	})
+
+	t.Run("Abort loop outside a loop returns an error", func(t *testing.T) {
+		text := `
+			OUTPUT <- 1
+			ABORT LOOP
+		`
+
+		_, errs := compile(t, text)
+		assertErrCode(t, errs, compiler.AbortOutsideLoopErrCode)
+	})
+
+	t.Run("Blocks larger than a 16 bit jump use long jumps", func(t *testing.T) {
+		var text strings.Builder
+		text.WriteString("N <- 0\nLOOP 2 TIMES\nIF N < 1 THEN\nN <- 0\nEND IF\n")
+		for i := 0; i < 10000; i++ {
+			text.WriteString("N <- N + 1\n")
+		}
+		text.WriteString("END LOOP\nOUTPUT <- N\n")
+
+		assert.Equal(t, vm.NewValue(20000), run(t, text.String()))
+	})
+}
+
+func TestCompiler_Compile_Cells(t *testing.T) {
+	t.Run("Cells can be assigned and read with any index expression", func(t *testing.T) {
+		text := `
+			DEFINE PROCEDURE "TWO-TO-THE-THREE-TO-THE" [N]
+				CELL(0) <- 1
+				LOOP N TIMES
+					CELL(0) <- 3 * CELL(0)
+				END LOOP
+				CELL(1) <- 1
+				LOOP CELL(0) TIMES
+					CELL(1) <- 2 * CELL(1)
+				END LOOP
+				OUTPUT <- CELL(1)
+			END PROCEDURE
+		`
+
+		assert.Equal(t, vm.NewValue(512), call(t, text, "TWO-TO-THE-THREE-TO-THE", 2))
+		assert.Equal(t, vm.NewValue(1<<27), call(t, text, "TWO-TO-THE-THREE-TO-THE", 3))
+	})
+
+	t.Run("Cells start as zero", func(t *testing.T) {
+		text := `
+			CELL(2 + 1) <- 5
+			OUTPUT <- CELL(3) + CELL(1000)
+		`
+
+		assert.Equal(t, vm.NewValue(5), run(t, text))
+	})
+
+	t.Run("Every procedure call has its own cells", func(t *testing.T) {
+		text := `
+			DEFINE PROCEDURE "SWAP" [N]
+				OUTPUT <- CELL(0)
+				CELL(0) <- N
+			END PROCEDURE
+			CELL(0) <- 7
+			OUTPUT <- SWAP[1] + SWAP[2] + CELL(0)
+		`
+
+		assert.Equal(t, vm.NewValue(7), run(t, text))
+	})
+
+	t.Run("Cell indexes and values must be numbers", func(t *testing.T) {
+		text := `
+			CELL(1 = 1) <- 5
+		`
+
+		_, errs := compile(t, text)
+		assertErrCode(t, errs, compiler.NumberExpressionNeededCodeErr)
+
+		text = `
+			CELL(0) <- 1 = 1
+		`
+
+		_, errs = compile(t, text)
+		assertErrCode(t, errs, compiler.InvalidTypeErrCode)
+	})
+
+	t.Run("Cells without an index return an error", func(t *testing.T) {
+		text := `
+			CELL <- 5
+		`
+
+		_, errs := compile(t, text)
+		assertErrCode(t, errs, compiler.ExpectedCellIndexErrCode)
+	})
+}
+
+func TestCompiler_Compile_Procedures(t *testing.T) {
+	t.Run("A script returns its OUTPUT", func(t *testing.T) {
+		text := `
+			OUTPUT <- 2 + 3
+		`
+
+		assert.Equal(t, vm.NewValue(5), run(t, text))
+	})
+
+	t.Run("A procedure reads its parameters and returns its OUTPUT", func(t *testing.T) {
+		text := `
+			DEFINE PROCEDURE "ADD" [M, N]
+				OUTPUT <- M + N
+			END PROCEDURE
+		`
+
+		assert.Equal(t, vm.NewValue(5), call(t, text, "ADD", 2, 3))
+	})
+
+	t.Run("Comments are ignored anywhere between tokens", func(t *testing.T) {
+		text := `
+			# ADD returns the sum of its parameters
+			DEFINE PROCEDURE "ADD" [M, { first } N]
+				OUTPUT <- M + { plus } N # the sum
+			END PROCEDURE
+			{ trailing comment }
+		`
+
+		assert.Equal(t, vm.NewValue(5), call(t, text, "ADD", 2, 3))
+	})
+
+	t.Run("Quit procedure returns the current OUTPUT", func(t *testing.T) {
+		text := `
+			DEFINE PROCEDURE "FIRST-IF-EQUAL" [M, N]
+				OUTPUT <- M
+				IF M = N THEN
+					QUIT PROCEDURE
+				END IF
+				OUTPUT <- M + N
+			END PROCEDURE
+		`
+
+		assert.Equal(t, vm.NewValue(2), call(t, text, "FIRST-IF-EQUAL", 2, 2))
+		assert.Equal(t, vm.NewValue(5), call(t, text, "FIRST-IF-EQUAL", 2, 3))
+	})
+
+	t.Run("OUTPUT starts as zero", func(t *testing.T) {
+		text := `
+			DEFINE PROCEDURE "ZERO" [N]
+				QUIT PROCEDURE
+			END PROCEDURE
+		`
+
+		assert.Equal(t, vm.NewValue(0), call(t, text, "ZERO", 7))
+	})
+
+	t.Run("Procedure variables are not visible outside the procedure", func(t *testing.T) {
+		text := `
+			DEFINE PROCEDURE "ID" [N]
+				OUTPUT <- N
+			END PROCEDURE
+			OUTPUT <- N
+		`
+
+		_, errs := compile(t, text)
+		assertErrCode(t, errs, compiler.UndefinedVariableErrCode)
+	})
+
+	t.Run("Procedures ending with a question mark output booleans", func(t *testing.T) {
+		text := `
+			DEFINE PROCEDURE "ZERO?" [N]
+				OUTPUT <- 1
+			END PROCEDURE
+		`
+
+		_, errs := compile(t, text)
+		assertErrCode(t, errs, compiler.InvalidTypeErrCode)
+	})
+
+	t.Run("Quit procedure outside a procedure returns an error", func(t *testing.T) {
+		text := `
+			OUTPUT <- 1
+			QUIT PROCEDURE
+		`
+
+		_, errs := compile(t, text)
+		assertErrCode(t, errs, compiler.QuitOutsideProcedureErrCode)
+	})
+
+	t.Run("Defining a procedure twice returns an error", func(t *testing.T) {
+		text := `
+			DEFINE PROCEDURE "ID" [N]
+				OUTPUT <- N
+			END PROCEDURE
+			DEFINE PROCEDURE "ID" [M]
+				OUTPUT <- M
+			END PROCEDURE
+		`
+
+		_, errs := compile(t, text)
+		assertErrCode(t, errs, compiler.DuplicatedProcedureErrCode)
+	})
+
+	t.Run("Malformed parameter lists return an error", func(t *testing.T) {
+		text := `
+			DEFINE PROCEDURE "ADD" [M N]
+				OUTPUT <- M
+			END PROCEDURE
+		`
+
+		_, errs := compile(t, text)
+		assertErrCode(t, errs, compiler.ExpectedParametersErrCode)
+	})
+}
+
+func TestCompiler_Compile_Procedure_Calls(t *testing.T) {
+	t.Run("Calls evaluate their arguments and push the procedure OUTPUT", func(t *testing.T) {
+		text := `
+			DEFINE PROCEDURE "ADD" [M, N]
+				OUTPUT <- M + N
+			END PROCEDURE
+			OUTPUT <- ADD[2 * 3, 1] + 1
+		`
+
+		assert.Equal(t, vm.NewValue(8), run(t, text))
+	})
+
+	t.Run("Procedure names are case insensitive", func(t *testing.T) {
+		text := `
+			DEFINE PROCEDURE "AddOne" [N]
+				OUTPUT <- N + 1
+			END PROCEDURE
+			OUTPUT <- addone[1] + ADDONE[2] + "addOne"[3]
+		`
+
+		assert.Equal(t, vm.NewValue(9), run(t, text))
+	})
+
+	t.Run("Names can have hyphens and any case", func(t *testing.T) {
+		text := `
+			DEFINE PROCEDURE "THREE-TO-THE" [N]
+				cell(0) <- 1
+				LOOP n TIMES
+					CELL(0) <- 3 * Cell(0)
+				END LOOP
+				output <- CELL(0)
+			END PROCEDURE
+
+			DEFINE PROCEDURE "TWO-TO-THE-THREE-TO-THE" [N]
+				OUTPUT <- 1
+				LOOP three-to-the[N] TIMES
+					Output <- 2 * OUTPUT
+				END LOOP
+			END PROCEDURE
+		`
+
+		assert.Equal(t, vm.NewValue(512), call(t, text, "TWO-TO-THE-THREE-TO-THE", 2))
+	})
+
+	t.Run("OUTPUT can't be a parameter", func(t *testing.T) {
+		_, errs := compile(t, `
+			DEFINE PROCEDURE "ONE" [N, output]
+				OUTPUT <- 1
+			END PROCEDURE
+		`)
+		assertErrCode(t, errs, compiler.ReservedNameErrCode)
+	})
+
+	t.Run("Quoted names need arguments", func(t *testing.T) {
+		_, errs := compile(t, `
+			DEFINE PROCEDURE "ONE" []
+				OUTPUT <- 1
+			END PROCEDURE
+			OUTPUT <- "ONE"
+		`)
+		assertErrCode(t, errs, compiler.ExpectedArgumentsErrCode)
+	})
+
+	t.Run("Procedures can be called before being defined", func(t *testing.T) {
+		text := `
+			DEFINE PROCEDURE "QUADRUPLE" [N]
+				OUTPUT <- DOUBLE[DOUBLE[N]]
+			END PROCEDURE
+
+			DEFINE PROCEDURE "DOUBLE" [N]
+				OUTPUT <- N + N
+			END PROCEDURE
+		`
+
+		assert.Equal(t, vm.NewValue(12), call(t, text, "QUADRUPLE", 3))
+	})
+
+	t.Run("Calls to tests return booleans", func(t *testing.T) {
+		text := `
+			DEFINE PROCEDURE "SMALL?" [N]
+				OUTPUT <- N < 10
+			END PROCEDURE
+			OUTPUT <- 1
+			IF SMALL?[3] THEN
+				OUTPUT <- 2
+			END IF
+		`
+
+		assert.Equal(t, vm.NewValue(2), run(t, text))
+	})
+
+	t.Run("Calling an undefined procedure returns an error", func(t *testing.T) {
+		text := `
+			OUTPUT <- MINUS[3, 2]
+		`
+
+		_, errs := compile(t, text)
+		assertErrCode(t, errs, compiler.UndefinedProcedureErrCode)
+	})
+
+	t.Run("Calling with the wrong amount of arguments returns an error", func(t *testing.T) {
+		text := `
+			DEFINE PROCEDURE "DOUBLE" [N]
+				OUTPUT <- N + N
+			END PROCEDURE
+			OUTPUT <- DOUBLE[1, 2]
+		`
+
+		_, errs := compile(t, text)
+		assertErrCode(t, errs, compiler.WrongArgumentCountErrCode)
+
+		text = `
+			OUTPUT <- DOUBLE[1, 2]
+			DEFINE PROCEDURE "DOUBLE" [N]
+				OUTPUT <- N + N
+			END PROCEDURE
+		`
+
+		_, errs = compile(t, text)
+		assertErrCode(t, errs, compiler.WrongArgumentCountErrCode)
+	})
+
+	t.Run("Boolean arguments return a number expression needed error", func(t *testing.T) {
+		text := `
+			DEFINE PROCEDURE "DOUBLE" [N]
+				OUTPUT <- N + N
+			END PROCEDURE
+			OUTPUT <- DOUBLE[1 = 1]
+		`
+
+		_, errs := compile(t, text)
+		assertErrCode(t, errs, compiler.NumberExpressionNeededCodeErr)
+	})
+
+	t.Run("Assigning a result of the wrong type returns an invalid type error", func(t *testing.T) {
+		text := `
+			DEFINE PROCEDURE "SMALL?" [N]
+				OUTPUT <- N < 10
+			END PROCEDURE
+			OUTPUT <- 1
+			OUTPUT <- SMALL?[3]
+		`
+
+		_, errs := compile(t, text)
+		assertErrCode(t, errs, compiler.InvalidTypeErrCode)
+	})
+
+	t.Run("Recursive procedures return an error", func(t *testing.T) {
+		text := `
+			DEFINE PROCEDURE "PING" [N]
+				OUTPUT <- PONG[N]
+			END PROCEDURE
+			DEFINE PROCEDURE "PONG" [N]
+				OUTPUT <- PING[N]
+			END PROCEDURE
+		`
+
+		_, errs := compile(t, text)
+		require.Equal(t, 2, len(errs))
+		assert.ErrorIs(t, errs[0], compiler.RecursiveProcedureErrCode)
+	})
+
+	t.Run("Calls past the procedures a 16 bit operand can address return an error", func(t *testing.T) {
+		var b strings.Builder
+		for i := 0; i <= 0xffff+1; i++ {
+			fmt.Fprintf(&b, "DEFINE PROCEDURE \"P%d\" [N]\nEND PROCEDURE\n", i)
+		}
+
+		chunk, errs := compile(t, b.String()+"OUTPUT <- P65535[1]")
+		require.Nil(t, errs)
+		assert.Equal(t, 0xffff+2, len(chunk.Procedures()))
+
+		_, errs = compile(t, b.String()+"OUTPUT <- P65536[1]")
+		assertErrCode(t, errs, compiler.TooManyProceduresErrCode)
+	})
+}
+
+func TestCompiler_Compile_Expressions(t *testing.T) {
+	prelude := `
+		DEFINE PROCEDURE "DOUBLE" [N]
+			OUTPUT <- N + N
+		END PROCEDURE
+		A <- 1
+		B <- 2
+		C <- 3
+		D <- 4
+	`
+
+	get := func(slot byte) []byte {
+		return []byte{vm.OpGet.Byte(), slot}
+	}
+
+	bytecode := func(parts ...interface{}) []byte {
+		var res []byte
+		for _, part := range parts {
+			switch p := part.(type) {
+			case vm.OpCode:
+				res = append(res, p.Byte())
+			case []byte:
+				res = append(res, p...)
+			case int:
+				res = append(res, byte(p))
+			}
+		}
+		return res
+	}
+
+	tests := []struct {
+		expression string
+		expected   []byte
+	}{
+		{"A + B + C", bytecode(get(0), get(1), vm.OpAdd, get(2), vm.OpAdd)},
+		{"A + B * C + D", bytecode(get(0), get(1), get(2), vm.OpMultiply, vm.OpAdd, get(3), vm.OpAdd)},
+		{"A * B + C * D", bytecode(get(0), get(1), vm.OpMultiply, get(2), get(3), vm.OpMultiply, vm.OpAdd)},
+		{"(A + B) * C", bytecode(get(0), get(1), vm.OpAdd, get(2), vm.OpMultiply)},
+		{"A * (B + C)", bytecode(get(0), get(1), get(2), vm.OpAdd, vm.OpMultiply)},
+		{"A + B < C * D", bytecode(get(0), get(1), vm.OpAdd, get(2), get(3), vm.OpMultiply, vm.OpLesser)},
+		{"A > B", bytecode(get(0), get(1), vm.OpGreater)},
+		{"A >= B", bytecode(get(0), get(1), vm.OpLesser, vm.OpNot)},
+		{"A <= B", bytecode(get(0), get(1), vm.OpGreater, vm.OpNot)},
+		{"A = B + 1", bytecode(get(0), get(1), vm.OpPush, 1, vm.OpAdd, vm.OpEqual)},
+		{"NOT (A = B)", bytecode(get(0), get(1), vm.OpEqual, vm.OpNot)},
+		{"DOUBLE[A + B] * C", bytecode(get(0), get(1), vm.OpAdd, vm.OpCall, 0, 0, get(2), vm.OpMultiply)},
+		{"A < B AND C > D", bytecode(get(0), get(1), vm.OpLesser, vm.OpJumpIfFalse, 0, 6, vm.OpPop, get(2), get(3), vm.OpGreater)},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s compiles in the right order", test.expression), func(t *testing.T) {
+			chunk, errs := compile(t, prelude+"X <- "+test.expression)
+			require.Nil(t, errs)
+
+			// Every assignment of the prelude takes four bytes
+			start := 16
+			actual := make([]byte, 0, len(test.expected))
+			for i := start; i < start+len(test.expected) && i < chunk.InstructionsCount(); i++ {
+				actual = append(actual, chunk.Read(i))
+			}
+
+			assert.Equal(t, test.expected, actual)
+			assert.Equal(t, vm.OpSet.Byte(), chunk.Read(start+len(test.expected)))
+		})
+	}
+
+	invalid := []struct {
+		expression string
+		code       compiler.ErrCode
+	}{
+		{"A + (A = B)", compiler.NumberExpressionNeededCodeErr},
+		{"(A = B) * C", compiler.NumberExpressionNeededCodeErr},
+		{"(A = B) < C", compiler.NumberExpressionNeededCodeErr},
+		{"A = (B = C)", compiler.MismatchedTypesErrCode},
+		{"A +", compiler.ExpectedExpressionErrCode},
+	}
+
+	for _, test := range invalid {
+		t.Run(fmt.Sprintf("%s returns an error", test.expression), func(t *testing.T) {
+			_, errs := compile(t, prelude+"X <- "+test.expression)
+			assertErrCode(t, errs, test.code)
+		})
+	}
+
+	t.Run("Long chains are evaluated left to right", func(t *testing.T) {
+		assert.Equal(t, vm.NewValue(1+2*3+4*5*6+7), run(t, `OUTPUT <- 1 + 2 * 3 + 4 * 5 * 6 + 7`))
+		assert.Equal(t, vm.NewValue(1), run(t, `OUTPUT <- 2 * 3 + 1 >= 7`))
+		assert.Equal(t, vm.NewValue(0), run(t, `OUTPUT <- 2 * 3 + 1 <= 6`))
+	})
+}
+
+func TestCompiler_Compile_Booleans(t *testing.T) {
+	t.Run("YES, NO and NOT can be used in predicates", func(t *testing.T) {
+		text := `
+			DEFINE PROCEDURE "EVEN?" [N]
+				OUTPUT <- YES
+				LOOP N TIMES
+					OUTPUT <- NOT OUTPUT
+				END LOOP
+			END PROCEDURE
+			DEFINE PROCEDURE "ODD?" [N]
+				OUTPUT <- NOT EVEN?[N]
+			END PROCEDURE
+		`
+
+		assert.Equal(t, vm.NewValue(1), call(t, text, "EVEN?", 4))
+		assert.Equal(t, vm.NewValue(0), call(t, text, "EVEN?", 7))
+		assert.Equal(t, vm.NewValue(0), call(t, text, "ODD?", 4))
+		assert.Equal(t, vm.NewValue(1), call(t, text, "ODD?", 7))
+	})
+
+	t.Run("Booleans can be compared for equality", func(t *testing.T) {
+		assert.Equal(t, vm.NewValue(1), run(t, `OUTPUT <- YES = NOT NO`))
+		assert.Equal(t, vm.NewValue(0), run(t, `OUTPUT <- (1 = 2) = YES`))
+		assert.Equal(t, vm.NewValue(1), run(t, `OUTPUT <- NOT NOT (NO = NO)`))
+	})
+
+	t.Run("NOT compiles its operand before negating it", func(t *testing.T) {
+		chunk, errs := compile(t, `B <- NOT YES`)
+		require.Nil(t, errs)
+
+		assert.Equal(t, vm.OpPush.Byte(), chunk.Read(0))
+		assert.Equal(t, byte(1), chunk.Read(1))
+		assert.Equal(t, vm.OpNot.Byte(), chunk.Read(2))
+	})
+
+	t.Run("NOT on a number returns an error", func(t *testing.T) {
+		_, errs := compile(t, `B <- NOT 3`)
+		assertErrCode(t, errs, compiler.BooleanExpressionNeededCodeErr)
+	})
+}
+
+func TestCompiler_Compile_Logical_Operators(t *testing.T) {
+	t.Run("AND and OR combine conditions", func(t *testing.T) {
+		text := `
+			DEFINE PROCEDURE "BETWEEN?" [N, LOW, HIGH]
+				IF N > LOW AND N < HIGH THEN
+					OUTPUT <- 1 = 1
+				END IF
+			END PROCEDURE
+			DEFINE PROCEDURE "OUTSIDE?" [N, LOW, HIGH]
+				IF N < LOW OR N > HIGH OR N = LOW AND N = HIGH THEN
+					OUTPUT <- 1 = 1
+				END IF
+			END PROCEDURE
+		`
+
+		assert.Equal(t, vm.NewValue(1), call(t, text, "BETWEEN?", 3, 2, 5))
+		assert.Equal(t, vm.NewValue(0), call(t, text, "BETWEEN?", 2, 2, 5))
+		assert.Equal(t, vm.NewValue(0), call(t, text, "BETWEEN?", 7, 2, 5))
+		assert.Equal(t, vm.NewValue(1), call(t, text, "OUTSIDE?", 1, 2, 5))
+		assert.Equal(t, vm.NewValue(1), call(t, text, "OUTSIDE?", 7, 2, 5))
+		assert.Equal(t, vm.NewValue(0), call(t, text, "OUTSIDE?", 2, 2, 5))
+		assert.Equal(t, vm.NewValue(1), call(t, text, "OUTSIDE?", 2, 2, 2))
+	})
+
+	t.Run("AND and OR don't evaluate the right operand when the left one decides", func(t *testing.T) {
+		text := `
+			N <- 0
+			IF N = 1 AND CELL(1024 * 1024 * 1024) = 0 THEN
+				N <- 1
+			END IF
+			IF N = 0 OR CELL(1024 * 1024 * 1024) = 0 THEN
+				N <- N + 2
+			END IF
+			OUTPUT <- N
+		`
+
+		assert.Equal(t, vm.NewValue(2), run(t, text))
+	})
+
+	t.Run("AND and OR leave the result on the stack", func(t *testing.T) {
+		chunk, errs := compile(t, `
+			B <- 1 = 1 AND 1 = 2
+		`)
+		require.Nil(t, errs)
+
+		expected := vm.NewChunk()
+		expected.Append(vm.OpPush.Byte(), 2, 1)
+		expected.Append(vm.OpPush.Byte(), 2, 1)
+		expected.Append(vm.OpEqual.Byte(), 2)
+		expected.Append(vm.OpJumpIfFalse.Byte(), 2, 0, 6)
+		expected.Append(vm.OpPop.Byte(), 2)
+		expected.Append(vm.OpPush.Byte(), 2, 1)
+		expected.Append(vm.OpPush.Byte(), 2, 2)
+		expected.Append(vm.OpEqual.Byte(), 2)
+
+		for i := 0; i < expected.InstructionsCount(); i++ {
+			assert.Equal(t, expected.Read(i), chunk.Read(i), "byte %d", i)
+		}
+	})
+
+	t.Run("Operands that are not booleans return an error", func(t *testing.T) {
+		_, errs := compile(t, `
+			B <- 1 AND 1 = 1
+		`)
+		assertErrCode(t, errs, compiler.BooleanExpressionNeededCodeErr)
+
+		_, errs = compile(t, `
+			B <- 1 = 1 OR 2
+		`)
+		assertErrCode(t, errs, compiler.BooleanExpressionNeededCodeErr)
+	})
+}
+
+func TestCompiler_Continue(t *testing.T) {
+	lex := func(text string) []compiler.Token {
+		tokens, errs := compiler.Lexer(text)
+		require.Nil(t, errs)
+		return tokens
+	}
+
+	t.Run("Variables and procedures are kept between calls", func(t *testing.T) {
+		c := compiler.New(nil)
+		machine := vm.New()
+
+		for _, text := range []string{
+			`N <- 20`,
+			`DEFINE PROCEDURE "DOUBLE" [M] OUTPUT <- M + M END PROCEDURE`,
+			`N <- DOUBLE[N] + 2`,
+		} {
+			chunk, expression, errs := c.Continue(lex(text))
+			require.Nil(t, errs)
+			assert.False(t, expression)
+			_, err := machine.Continue(chunk)
+			require.Nil(t, err)
+		}
+
+		chunk, expression, errs := c.Continue(lex(`N = 42`))
+		require.Nil(t, errs)
+		assert.True(t, expression)
+		assert.Equal(t, vm.BooleanKind, chunk.Output())
+
+		res, err := machine.Continue(chunk)
+		require.Nil(t, err)
+		assert.Equal(t, "YES", res.Format(chunk.Output()))
+	})
+
+	t.Run("Nothing is kept from tokens that don't compile", func(t *testing.T) {
+		c := compiler.New(nil)
+
+		_, _, errs := c.Continue(lex(`DEFINE PROCEDURE "ID" [N] OUTPUT <- M END PROCEDURE`))
+		assertErrCode(t, errs, compiler.UndefinedVariableErrCode)
+
+		_, _, errs = c.Continue(lex(`N <- ID[1]`))
+		assertErrCode(t, errs, compiler.UndefinedProcedureErrCode)
+
+		_, _, errs = c.Continue(lex(`DEFINE PROCEDURE "ID" [N] OUTPUT <- N END PROCEDURE`))
+		require.Nil(t, errs)
+
+		_, _, errs = c.Continue(lex(`N`))
+		assertErrCode(t, errs, compiler.UndefinedVariableErrCode)
+	})
+
+	t.Run("Unfinished blocks and expressions are incomplete", func(t *testing.T) {
+		assert.True(t, compiler.IsIncomplete(lex(`LOOP 3 TIMES OUTPUT <- 1`)))
+		assert.True(t, compiler.IsIncomplete(lex(`DEFINE PROCEDURE "ID" [N]`)))
+		assert.True(t, compiler.IsIncomplete(lex(`IF N < 2 THEN N <- 1 ELSE IF N < 3 THEN`)))
+		assert.True(t, compiler.IsIncomplete(lex(`OUTPUT <- MINUS[3,`)))
+		assert.True(t, compiler.IsIncomplete(lex(`OUTPUT <-`)))
+		assert.False(t, compiler.IsIncomplete(lex(`IF N < 2 THEN N <- 1 ELSE IF N < 3 THEN N <- 2 END IF`)))
+		assert.False(t, compiler.IsIncomplete(lex(`OUTPUT <- MINUS[3, 2]`)))
 	})
 }
 
@@ -0,0 +1,290 @@
+package compiler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gonzispina/gloop/ast"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Severity tells whether a diagnostic stops the program from compiling
+type Severity uint8
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		// Unreachable
+		return ""
+	}
+}
+
+// Label points at a piece of the source related to a diagnostic
+type Label struct {
+	Span    ast.Span
+	Message string
+}
+
+// Fix is a change to the source that would solve a diagnostic
+type Fix struct {
+	Span        ast.Span
+	Replacement string
+	Message     string
+}
+
+// Diagnostic is a problem found in the source. Every error returned by the
+// lexer and the compiler is a *Diagnostic, and errors.Is matches it against
+// its ErrCode
+type Diagnostic struct {
+	Severity Severity
+	Code     ErrCode
+	Message  string
+	Span     ast.Span
+	Labels   []Label
+	Notes    []string
+	Fix      *Fix
+}
+
+func (d *Diagnostic) Error() string {
+	p := d.Span.Start
+	location := fmt.Sprintf("Line %v Column %v", p.Line, p.Column)
+	if p.File != "" {
+		location = p.String()
+	}
+	return fmt.Sprintf("%s: %s. ErrCode: %s", location, d.Message, string(d.Code))
+}
+
+// Is reports whether target is the ErrCode of the diagnostic
+func (d *Diagnostic) Is(target error) bool {
+	code, ok := target.(ErrCode)
+	return ok && code == d.Code
+}
+
+func (d *Diagnostic) label(span ast.Span, message string) *Diagnostic {
+	d.Labels = append(d.Labels, Label{Span: span, Message: message})
+	return d
+}
+
+func (d *Diagnostic) note(note string) *Diagnostic {
+	d.Notes = append(d.Notes, note)
+	return d
+}
+
+func (d *Diagnostic) fix(span ast.Span, replacement string, message string) *Diagnostic {
+	d.Fix = &Fix{Span: span, Replacement: replacement, Message: message}
+	return d
+}
+
+// Render writes the diagnostic followed by the source lines it points at,
+// with the primary span underlined with ^~~~ and labels underlined with ---:
+//
+//	error[Undefined variable]: cannot evaluate 'N' ...
+//	 --> minus.bloop:3:15
+//	  |
+//	3 |     OUTPUT <- N
+//	  |               ^
+//	  = note: ...
+//
+// Marks on the same line are underlined below one copy of it. The source is
+// the text of the file of the primary span. Labels pointing into other files
+// are listed after ::: with their position only.
+func (d *Diagnostic) Render(w io.Writer, source string) {
+	lines := strings.Split(source, "\n")
+	start := d.Span.Start
+
+	type mark struct {
+		span    ast.Span
+		primary bool
+		message string
+	}
+
+	marks := []mark{{span: d.Span, primary: true}}
+	for _, l := range d.Labels {
+		marks = append(marks, mark{span: l.Span, message: l.Message})
+	}
+
+	width := len(fmt.Sprint(start.Line))
+	for _, m := range marks {
+		if n := len(fmt.Sprint(m.span.Start.Line)); n > width && m.span.Start.File == start.File {
+			width = n
+		}
+	}
+	gutter := strings.Repeat(" ", width)
+
+	fmt.Fprintf(w, "%s[%s]: %s\n", d.Severity, d.Code, d.Message)
+	fmt.Fprintf(w, "%s--> %s\n", gutter, start)
+	fmt.Fprintf(w, "%s |\n", gutter)
+
+	// Lines keep the order of their first mark
+	var order []int
+	byLine := map[int][]mark{}
+	var elsewhere []mark
+	for _, m := range marks {
+		if m.span.Start.File != start.File {
+			elsewhere = append(elsewhere, m)
+			continue
+		}
+
+		line := m.span.Start.Line
+		if line < 1 || line > len(lines) {
+			continue
+		}
+
+		if _, ok := byLine[line]; !ok {
+			order = append(order, line)
+		}
+		byLine[line] = append(byLine[line], m)
+	}
+
+	for _, line := range order {
+		text := strings.TrimRight(lines[line-1], "\r")
+		fmt.Fprintf(w, "%*d | %s\n", width, line, text)
+		for _, m := range byLine[line] {
+			fmt.Fprintf(w, "%s | %s\n", gutter, underline(text, m.span, m.primary, m.message))
+		}
+	}
+
+	for _, m := range elsewhere {
+		fmt.Fprintf(w, "%s ::: %s: %s\n", gutter, m.span.Start, m.message)
+	}
+
+	for _, note := range d.Notes {
+		fmt.Fprintf(w, "%s = note: %s\n", gutter, note)
+	}
+
+	if d.Fix != nil {
+		fmt.Fprintf(w, "%s = help: %s\n", gutter, d.Fix.Message)
+	}
+}
+
+// underline marks the span within its first line. Tabs before the span are
+// kept so the marks line up with the text above them
+func underline(text string, span ast.Span, primary bool, message string) string {
+	first, rest := "-", "-"
+	if primary {
+		first, rest = "^", "~"
+	}
+
+	var b strings.Builder
+	column := 1
+	for _, r := range text {
+		if column >= span.Start.Column {
+			break
+		}
+		if r == '\t' {
+			b.WriteRune('\t')
+		} else {
+			b.WriteRune(' ')
+		}
+		column++
+	}
+
+	length := 1
+	if span.End.Line == span.Start.Line && span.End.Column > span.Start.Column {
+		length = span.End.Column - span.Start.Column
+	} else if span.End.Line > span.Start.Line {
+		length = utf8.RuneCountInString(text) - span.Start.Column + 1
+	}
+
+	b.WriteString(first)
+	if length > 1 {
+		b.WriteString(strings.Repeat(rest, length-1))
+	}
+
+	if message != "" {
+		b.WriteString(" " + message)
+	}
+	return b.String()
+}
+
+type jsonPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	Offset int `json:"offset"`
+}
+
+type jsonSpan struct {
+	File  string       `json:"file,omitempty"`
+	Start jsonPosition `json:"start"`
+	End   jsonPosition `json:"end"`
+}
+
+type jsonLabel struct {
+	Span    jsonSpan `json:"span"`
+	Message string   `json:"message"`
+}
+
+type jsonFix struct {
+	Span        jsonSpan `json:"span"`
+	Replacement string   `json:"replacement"`
+	Message     string   `json:"message"`
+}
+
+type jsonDiagnostic struct {
+	Severity string      `json:"severity"`
+	Code     string      `json:"code"`
+	Message  string      `json:"message"`
+	Span     jsonSpan    `json:"span"`
+	Labels   []jsonLabel `json:"labels,omitempty"`
+	Notes    []string    `json:"notes,omitempty"`
+	Fix      *jsonFix    `json:"fix,omitempty"`
+}
+
+func toJSONSpan(s ast.Span) jsonSpan {
+	return jsonSpan{
+		File:  s.Start.File,
+		Start: jsonPosition{Line: s.Start.Line, Column: s.Start.Column, Offset: s.Start.Offset},
+		End:   jsonPosition{Line: s.End.Line, Column: s.End.Column, Offset: s.End.Offset},
+	}
+}
+
+// RenderJSON writes the diagnostics as a JSON array for tools
+func RenderJSON(w io.Writer, diagnostics []*Diagnostic) error {
+	res := make([]jsonDiagnostic, 0, len(diagnostics))
+	for _, d := range diagnostics {
+		j := jsonDiagnostic{
+			Severity: d.Severity.String(),
+			Code:     string(d.Code),
+			Message:  d.Message,
+			Span:     toJSONSpan(d.Span),
+			Notes:    d.Notes,
+		}
+
+		for _, l := range d.Labels {
+			j.Labels = append(j.Labels, jsonLabel{Span: toJSONSpan(l.Span), Message: l.Message})
+		}
+
+		if d.Fix != nil {
+			j.Fix = &jsonFix{Span: toJSONSpan(d.Fix.Span), Replacement: d.Fix.Replacement, Message: d.Fix.Message}
+		}
+		res = append(res, j)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(res)
+}
+
+// Diagnostics returns the diagnostics among the errors, skipping any other
+// error
+func Diagnostics(errs []error) []*Diagnostic {
+	var res []*Diagnostic
+	for _, err := range errs {
+		var d *Diagnostic
+		if errors.As(err, &d) {
+			res = append(res, d)
+		}
+	}
+	return res
+}
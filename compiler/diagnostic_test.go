@@ -0,0 +1,132 @@
+package compiler_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/gonzispina/gloop/compiler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func diagnostic(t *testing.T, text string) *compiler.Diagnostic {
+	tokens, errs := compiler.Lex("test.bloop", text)
+	require.Nil(t, errs)
+
+	_, errs = compiler.New(tokens).Compile()
+	require.Equal(t, 1, len(errs))
+
+	var d *compiler.Diagnostic
+	require.True(t, errors.As(errs[0], &d))
+	return d
+}
+
+func TestDiagnostic(t *testing.T) {
+	t.Run("Diagnostics match their error code", func(t *testing.T) {
+		d := diagnostic(t, "OUTPUT <- N")
+
+		assert.ErrorIs(t, d, compiler.UndefinedVariableErrCode)
+		assert.False(t, errors.Is(d, compiler.UndefinedProcedureErrCode))
+		assert.Equal(t, compiler.SeverityError, d.Severity)
+		assert.Equal(t, "test.bloop:1:11: cannot evaluate 'N' because it wasn't assigned before: N <- Value. ErrCode: Undefined variable", d.Error())
+	})
+
+	t.Run("The caret renderer underlines the primary span and the labels below their line", func(t *testing.T) {
+		text := "A <- 1\nB <- YES\nOUTPUT <- A + 10 = B"
+		d := diagnostic(t, text)
+		require.ErrorIs(t, d, compiler.MismatchedTypesErrCode)
+
+		var b strings.Builder
+		d.Render(&b, text)
+		assert.Equal(t, strings.Join([]string{
+			"error[Mismatched types]: cannot compare 'number' value with 'boolean' value",
+			" --> test.bloop:3:11",
+			"  |",
+			"3 | OUTPUT <- A + 10 = B",
+			"  |           ^~~~~~~~~~",
+			"  |           ------ this is a number",
+			"  |                    - this is a boolean",
+			"",
+		}, "\n"), b.String())
+	})
+
+	t.Run("The caret renderer prints notes and suggested fixes", func(t *testing.T) {
+		text := "IF YES\n\tOUTPUT <- 1\nEND IF"
+		d := diagnostic(t, text)
+		require.ErrorIs(t, d, compiler.ExpectedThenErrCode)
+		require.NotNil(t, d.Fix)
+		assert.Equal(t, "THEN ", d.Fix.Replacement)
+
+		var b strings.Builder
+		d.Render(&b, text)
+		assert.Contains(t, b.String(), "2 | \tOUTPUT <- 1\n  | \t^~~~~~\n")
+		assert.Contains(t, b.String(), "  = help: add 'THEN' after the condition\n")
+
+		d = diagnostic(t, "N <- 1\nN <- YES")
+		b.Reset()
+		d.Render(&b, "N <- 1\nN <- YES")
+		assert.Contains(t, b.String(), "  |      ^~~\n")
+		assert.Contains(t, b.String(), "  = note: variables keep the type of their first assignment\n")
+	})
+
+	t.Run("Duplicated procedures point at the first definition", func(t *testing.T) {
+		d := diagnostic(t, `
+			DEFINE PROCEDURE "A" [N]
+			END PROCEDURE
+			DEFINE PROCEDURE "A" [N]
+			END PROCEDURE
+		`)
+
+		require.ErrorIs(t, d, compiler.DuplicatedProcedureErrCode)
+		assert.Equal(t, 4, d.Span.Start.Line)
+		require.Equal(t, 1, len(d.Labels))
+		assert.Equal(t, 2, d.Labels[0].Span.Start.Line)
+		assert.Equal(t, "first defined here", d.Labels[0].Message)
+	})
+
+	t.Run("Labels in other files are listed without their source", func(t *testing.T) {
+		library, errs := compiler.Lex("library.bloop", "DEFINE PROCEDURE \"A\" [N]\nEND PROCEDURE\n")
+		require.Nil(t, errs)
+		text := "\n\nOUTPUT <- A[1, 2]"
+		tokens, errs := compiler.Lex("test.bloop", text)
+		require.Nil(t, errs)
+
+		_, errs = compiler.New(append(library[:len(library)-1], tokens...)).Compile()
+		require.Equal(t, 1, len(errs))
+		require.ErrorIs(t, errs[0], compiler.WrongArgumentCountErrCode)
+
+		var b strings.Builder
+		compiler.Diagnostics(errs)[0].Render(&b, text)
+		assert.Contains(t, b.String(), "3 | OUTPUT <- A[1, 2]\n")
+		assert.Contains(t, b.String(), "  ::: library.bloop:1:18: defined here\n")
+		assert.NotContains(t, b.String(), "1 |")
+	})
+
+	t.Run("The JSON renderer writes every diagnostic", func(t *testing.T) {
+		d := diagnostic(t, "LOOP 3\n\tOUTPUT <- 1\nEND LOOP")
+
+		var b bytes.Buffer
+		require.Nil(t, compiler.RenderJSON(&b, []*compiler.Diagnostic{d}))
+
+		var res []map[string]interface{}
+		require.Nil(t, json.Unmarshal(b.Bytes(), &res))
+		require.Equal(t, 1, len(res))
+		assert.Equal(t, "error", res[0]["severity"])
+		assert.Equal(t, string(compiler.ExpectedTimesAfterLoopErrCode), res[0]["code"])
+
+		span := res[0]["span"].(map[string]interface{})
+		assert.Equal(t, "test.bloop", span["file"])
+		assert.Equal(t, map[string]interface{}{"line": 2.0, "column": 2.0, "offset": 8.0}, span["start"])
+
+		fix := res[0]["fix"].(map[string]interface{})
+		assert.Equal(t, "TIMES ", fix["replacement"])
+	})
+
+	t.Run("Diagnostics skips errors that aren't diagnostics", func(t *testing.T) {
+		d := diagnostic(t, "OUTPUT <- N")
+		res := compiler.Diagnostics([]error{errors.New("io"), d})
+		assert.Equal(t, []*compiler.Diagnostic{d}, res)
+	})
+}
@@ -1,111 +1,269 @@
 package compiler
 
 import (
-	"errors"
 	"fmt"
+	"github.com/gonzispina/gloop/ast"
+	"unicode/utf8"
 )
 
+// ErrCode identifies every kind of diagnostic. It is an error itself, so
+// diagnostics match it with errors.Is(err, UndefinedVariableErrCode)
 type ErrCode string
 
+func (c ErrCode) Error() string {
+	return string(c)
+}
+
 const (
-	UnexpectedEOFErrCode              = "Unexpected end of file"
-	ExpectedAssignmentOperatorErrCode = "Expected assignment operator"
-	InvalidTypeErrCode                = "Invalid variable type"
-	UnexpectedTokenErrCode            = "Unexpected token"
-	ExpectedExpressionErrCode         = "Expected expression"
-	ExpectedRightParenErrCode         = "Expected right parenthesis"
-	ExpectedThenErrCode               = "Expected then"
-	ExpectedEndIfErrCode              = "Expected end if"
-	ExpectedTimesAfterLoopErrCode     = "Expected times"
-	ExpectedEndLoopAfterLoopErrCode   = "Expected end loop"
-	UndefinedVariableErrCode          = "Undefined variable"
-	BlockIsTooLargeErrCode            = "Block is too large"
-	BooleanExpressionNeededCodeErr    = "Boolean expression needed"
-	NumberExpressionNeededCodeErr     = "Number expression needed"
+	UnexpectedEOFErrCode              ErrCode = "Unexpected end of file"
+	ExpectedAssignmentOperatorErrCode ErrCode = "Expected assignment operator"
+	InvalidTypeErrCode                ErrCode = "Invalid variable type"
+	UnexpectedTokenErrCode            ErrCode = "Unexpected token"
+	ExpectedExpressionErrCode         ErrCode = "Expected expression"
+	ExpectedRightParenErrCode         ErrCode = "Expected right parenthesis"
+	ExpectedThenErrCode               ErrCode = "Expected then"
+	ExpectedEndIfErrCode              ErrCode = "Expected end if"
+	ExpectedTimesAfterLoopErrCode     ErrCode = "Expected times"
+	UndefinedVariableErrCode          ErrCode = "Undefined variable"
+	UninitializedVariableErrCode      ErrCode = "Uninitialized variable"
+	BlockIsTooLargeErrCode            ErrCode = "Block is too large"
+	BooleanExpressionNeededCodeErr    ErrCode = "Boolean expression needed"
+	NumberExpressionNeededCodeErr     ErrCode = "Number expression needed"
+	QuitOutsideProcedureErrCode       ErrCode = "Quit procedure outside procedure"
+	NestedProcedureErrCode            ErrCode = "Nested procedure"
+	ExpectedProcedureNameErrCode      ErrCode = "Expected procedure name"
+	DuplicatedProcedureErrCode        ErrCode = "Duplicated procedure"
+	ExpectedParametersErrCode         ErrCode = "Expected parameters"
+	DuplicatedParameterErrCode        ErrCode = "Duplicated parameter"
+	ExpectedArgumentsErrCode          ErrCode = "Expected arguments"
+	UndefinedProcedureErrCode         ErrCode = "Undefined procedure"
+	WrongArgumentCountErrCode         ErrCode = "Wrong argument count"
+	RecursiveProcedureErrCode         ErrCode = "Recursive procedure"
+	TooManyConstantsErrCode           ErrCode = "Too many constants"
+	TooManyLocalsErrCode              ErrCode = "Too many locals"
+	TooManyProceduresErrCode          ErrCode = "Too many procedures"
+	AbortOutsideLoopErrCode           ErrCode = "Abort loop outside loop"
+	ExpectedCellIndexErrCode          ErrCode = "Expected cell index"
+	MismatchedTypesErrCode            ErrCode = "Mismatched types"
+	UnexpectedCharacterErrCode        ErrCode = "Unexpected character"
+	ExpectedKeywordErrCode            ErrCode = "Expected keyword"
+	UnterminatedCommentErrCode        ErrCode = "Unterminated comment"
+	UnterminatedNameErrCode           ErrCode = "Unterminated name"
+	InvalidProcedureNameErrCode       ErrCode = "Invalid procedure name"
+	ReservedNameErrCode               ErrCode = "Reserved name"
+	NonStandardKeywordErrCode         ErrCode = "Non standard keyword"
 )
 
-func compileErr(t Token, message string, code ErrCode) error {
-	return errors.New(fmt.Sprintf(
-		"Line %v Column %v: %s'",
-		t.line,
-		t.column,
-		fmt.Sprintf("%s. ErrCode: %s", message, string(code)),
-	))
+func compileErr(span ast.Span, message string, code ErrCode) *Diagnostic {
+	return &Diagnostic{Severity: SeverityError, Code: code, Message: message, Span: span}
+}
+
+// at is the empty span at the given position, used where there is no text to
+// point at, like the place where a missing keyword should go
+func at(p ast.Pos) ast.Span {
+	return ast.Span{Start: p, End: p}
 }
 
-func unexpectedEndOfFileErr(t Token) error {
-	return compileErr(t, "unexpected end of file", UnexpectedEOFErrCode)
+func unexpectedEndOfFileErr(span ast.Span) error {
+	return compileErr(span, "unexpected end of file", UnexpectedEOFErrCode)
 }
 
-func expectedAssignmentOperatorErr(t Token) error {
-	return compileErr(t, "expected assignment operator '<-'", ExpectedAssignmentOperatorErrCode)
+func expectedAssignmentOperatorErr(span ast.Span) error {
+	return compileErr(span, "expected assignment operator '<-'", ExpectedAssignmentOperatorErrCode)
 }
 
-func invalidTypeErr(t Token, expected varType, got varType) error {
-	return compileErr(t, fmt.Sprintf(
+func invalidTypeErr(span ast.Span, expected ast.Type, got ast.Type) error {
+	return compileErr(span, fmt.Sprintf(
 		"cannot assign '%s' value to variable of type '%s'",
 		got.String(),
 		expected.String(),
-	), InvalidTypeErrCode)
+	), InvalidTypeErrCode).
+		note("variables keep the type of their first assignment")
 }
 
 func unexpectedTokenErr(t Token) error {
-	return compileErr(t, fmt.Sprintf(
+	return compileErr(t.Span(), fmt.Sprintf(
 		"unexpected token '%s'",
 		t.lexeme,
 	), UnexpectedTokenErrCode)
 }
 
-func expectedExpressionErr(t Token) error {
-	return compileErr(t, "expected expression", ExpectedExpressionErrCode)
+func expectedExpressionErr(span ast.Span) error {
+	return compileErr(span, "expected expression", ExpectedExpressionErrCode)
 }
 
-func expectedRightParenthesisErr(t Token) error {
-	return compileErr(t, "expected closing parenthesis", ExpectedRightParenErrCode)
+func expectedRightParenthesisErr(span ast.Span) error {
+	return compileErr(span, "expected closing parenthesis", ExpectedRightParenErrCode).
+		fix(at(span.Start), ")", "close the parenthesis")
 }
 
-func expectedThenErr(t Token) error {
-	return compileErr(t, "expected 'then' after expression", ExpectedThenErrCode)
+func expectedThenErr(span ast.Span) error {
+	return compileErr(span, "expected 'then' after expression", ExpectedThenErrCode).
+		fix(at(span.Start), "THEN ", "add 'THEN' after the condition")
 }
 
-func expectedEndIfErr(t Token) error {
-	return compileErr(t, "expected 'end if' after block", ExpectedEndIfErrCode)
+func expectedEndIfErr(span ast.Span) error {
+	return compileErr(span, "expected 'end if' after block", ExpectedEndIfErrCode).
+		fix(at(span.Start), "END IF ", "close the block with 'END IF'")
 }
 
-func expectedTimesErr(t Token) error {
-	return compileErr(t, "expected 'times' after expression", ExpectedTimesAfterLoopErrCode)
+func expectedTimesErr(span ast.Span) error {
+	return compileErr(span, "expected 'times' after expression", ExpectedTimesAfterLoopErrCode).
+		fix(at(span.Start), "TIMES ", "add 'TIMES' after the amount of iterations")
 }
 
-func expectedEndLoopErr(t Token) error {
-	return compileErr(t, "expected 'end loop' after block", ExpectedEndLoopAfterLoopErrCode)
+func blockIsTooLargeErr(span ast.Span) error {
+	return compileErr(span, "block is too large", BlockIsTooLargeErrCode)
 }
 
-func blockIsTooLargeErr(t Token) error {
-	return compileErr(t, "block is too large", BlockIsTooLargeErrCode)
-}
-
-func undefinedVariableErr(t Token, name string) error {
-	return compileErr(t,
+func undefinedVariableErr(span ast.Span, name string) error {
+	return compileErr(span,
 		fmt.Sprintf("cannot evaluate '%s' because it wasn't assigned before: %s <- Value",
 			name,
 			name,
 		),
 		UndefinedVariableErrCode,
+	).note("variables are declared by their first assignment")
+}
+
+func uninitializedVariableErr(span ast.Span, name string) error {
+	return compileErr(span,
+		fmt.Sprintf("cannot evaluate '%s' before its first assignment is complete",
+			name,
+		),
+		UninitializedVariableErrCode,
 	)
 }
 
-func booleanExpressionNeededErr(t Token) error {
+func booleanExpressionNeededErr(span ast.Span) error {
 	return compileErr(
-		t,
+		span,
 		"needed boolean expression",
 		BooleanExpressionNeededCodeErr,
 	)
 }
 
-func numberExpressionNeededErr(t Token) error {
+func numberExpressionNeededErr(span ast.Span) error {
 	return compileErr(
-		t,
+		span,
 		"needed number expression",
 		NumberExpressionNeededCodeErr,
 	)
 }
+
+func quitOutsideProcedureErr(span ast.Span) error {
+	return compileErr(span, "'quit procedure' can only be used inside a procedure", QuitOutsideProcedureErrCode)
+}
+
+func nestedProcedureErr(span ast.Span) error {
+	return compileErr(span, "procedures cannot be defined inside other procedures", NestedProcedureErrCode)
+}
+
+func expectedProcedureNameErr(span ast.Span) error {
+	return compileErr(span, "expected procedure name after 'define procedure'", ExpectedProcedureNameErrCode)
+}
+
+func duplicatedProcedureErr(span ast.Span, name string, first ast.Span) error {
+	return compileErr(span, fmt.Sprintf("procedure '%s' is already defined", name), DuplicatedProcedureErrCode).
+		label(first, "first defined here")
+}
+
+func expectedParametersErr(span ast.Span) error {
+	return compileErr(span, "expected parameter list like '[M, N]' after procedure name", ExpectedParametersErrCode)
+}
+
+func duplicatedParameterErr(span ast.Span, name string) error {
+	return compileErr(span, fmt.Sprintf("parameter '%s' is declared more than once", name), DuplicatedParameterErrCode)
+}
+
+func expectedArgumentsErr(span ast.Span) error {
+	return compileErr(span, "expected argument list like '[M, N]' after procedure name", ExpectedArgumentsErrCode)
+}
+
+func undefinedProcedureErr(span ast.Span, name string) error {
+	return compileErr(span, fmt.Sprintf("procedure '%s' is never defined", name), UndefinedProcedureErrCode)
+}
+
+func wrongArgumentCountErr(span ast.Span, name string, expected int, got int, declaration ast.Span) error {
+	return compileErr(span, fmt.Sprintf(
+		"procedure '%s' expects %v arguments but got %v",
+		name,
+		expected,
+		got,
+	), WrongArgumentCountErrCode).
+		label(declaration, "defined here")
+}
+
+func recursiveProcedureErr(span ast.Span, name string) error {
+	return compileErr(span, fmt.Sprintf(
+		"procedure '%s' calls itself, BlooP procedures can only call other procedures",
+		name,
+	), RecursiveProcedureErrCode).
+		note("BlooP programs always finish, so procedures can't call themselves, not even through other procedures")
+}
+
+func tooManyConstantsErr(span ast.Span) error {
+	return compileErr(span, "too many different constants in one procedure", TooManyConstantsErrCode)
+}
+
+func tooManyLocalsErr(span ast.Span) error {
+	return compileErr(span, "too many variables in one procedure", TooManyLocalsErrCode)
+}
+
+func tooManyProceduresErr(span ast.Span) error {
+	return compileErr(span, "too many procedures in one program", TooManyProceduresErrCode)
+}
+
+func abortOutsideLoopErr(span ast.Span) error {
+	return compileErr(span, "'abort loop' can only be used inside a loop", AbortOutsideLoopErrCode)
+}
+
+func expectedCellIndexErr(span ast.Span) error {
+	return compileErr(span, "expected index between parenthesis after 'cell' like CELL(0)", ExpectedCellIndexErrCode)
+}
+
+func mismatchedTypesErr(span ast.Span, left ast.Expression, right ast.Expression) error {
+	return compileErr(span, fmt.Sprintf(
+		"cannot compare '%s' value with '%s' value",
+		left.Type().String(),
+		right.Type().String(),
+	), MismatchedTypesErrCode).
+		label(left.Span(), "this is a "+left.Type().String()).
+		label(right.Span(), "this is a "+right.Type().String())
+}
+
+func unexpectedCharacterErr(span ast.Span, text string) error {
+	message := fmt.Sprintf("unexpected character '%s'", text)
+	if utf8.RuneCountInString(text) > 1 {
+		message = fmt.Sprintf("unexpected characters '%s'", text)
+	}
+	return compileErr(span, message, UnexpectedCharacterErrCode)
+}
+
+func expectedKeywordErr(span ast.Span, expected string) error {
+	return compileErr(span, fmt.Sprintf("expected %s statement", expected), ExpectedKeywordErrCode)
+}
+
+func unterminatedCommentErr(span ast.Span) error {
+	return compileErr(span, "comment is never closed", UnterminatedCommentErrCode).
+		note("block comments end with '}'")
+}
+
+func unterminatedNameErr(span ast.Span) error {
+	return compileErr(span, "procedure name is never closed", UnterminatedNameErrCode).
+		fix(at(span.End), "\"", "close the name with '\"' before the end of the line")
+}
+
+func invalidProcedureNameErr(span ast.Span, name string) error {
+	return compileErr(span, fmt.Sprintf("'%s' is not a valid procedure name", name), InvalidProcedureNameErrCode).
+		note("procedure names are letters and digits joined by hyphens, like \"TWIN-PRIME?\", and tests end with '?'")
+}
+
+func reservedNameErr(span ast.Span, name string) error {
+	return compileErr(span, fmt.Sprintf("'%s' is a reserved word and can't be used as a name", name), ReservedNameErrCode)
+}
+
+func nonStandardKeywordErr(span ast.Span, keyword string) error {
+	return compileErr(span, fmt.Sprintf("keyword must be written as '%s'", keyword), NonStandardKeywordErrCode).
+		fix(span, keyword, fmt.Sprintf("write '%s'", keyword))
+}
@@ -5,7 +5,6 @@ import (
 	"github.com/gonzispina/gloop/vm"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	"strings"
 	"testing"
 )
 
@@ -15,13 +14,36 @@ func compile(t *testing.T, text string) (vm.Chunk, []error) {
 }
 
 func getCompiler(t *testing.T, text string) *compiler.Compiler {
-	tokens, err := compiler.Lexer(text)
-	require.Nil(t, err)
+	tokens, errs := compiler.Lexer(text)
+	require.Nil(t, errs)
 
 	return compiler.New(tokens)
 }
 
-func assertErrContains(t *testing.T, errs []error, code compiler.ErrCode) {
+func assertErrCode(t *testing.T, errs []error, code compiler.ErrCode) {
 	require.Equal(t, 1, len(errs))
-	assert.True(t, strings.Contains(errs[0].Error(), string(code)))
+	assert.ErrorIs(t, errs[0], code)
+}
+
+func run(t *testing.T, text string) vm.Value {
+	chunk, errs := compile(t, text)
+	require.Nil(t, errs)
+
+	res, err := vm.New().Run(chunk)
+	require.Nil(t, err)
+	return res
+}
+
+func call(t *testing.T, text string, name string, args ...uint64) vm.Value {
+	chunk, errs := compile(t, text)
+	require.Nil(t, errs)
+
+	values := make([]vm.Value, len(args))
+	for i, arg := range args {
+		values[i] = vm.NewValue(arg)
+	}
+
+	res, err := vm.New().Call(chunk, name, values...)
+	require.Nil(t, err)
+	return res
 }
@@ -0,0 +1,421 @@
+package compiler
+
+import (
+	"bufio"
+	"github.com/gonzispina/gloop/ast"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+func isNumber(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isLetter(r rune) bool {
+	return unicode.IsLetter(r)
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r'
+}
+
+// startsToken reports whether the lexer knows what to do with the rune
+func startsToken(r rune) bool {
+	return isSpace(r) || isLetter(r) || isNumber(r) || strings.ContainsRune(`"+*=<>()[],#{`, r)
+}
+
+// Lexer splits the text in tokens
+func Lexer(text string) ([]Token, []error) {
+	return Lex("", text)
+}
+
+// Lex splits the text of the named file in tokens. Every token knows the
+// file, line and column it starts at, and keeps the comments written before
+// it. Text that can't be lexed is reported and replaced by an Illegal token,
+// so every error of the file is found in one pass
+func Lex(file string, text string) ([]Token, []error) {
+	return lex(NewScanner(file, strings.NewReader(text)))
+}
+
+// LexStrict works like Lex with the scanner in strict mode, where keywords of
+// two words must be written like in GEB
+func LexStrict(file string, text string) ([]Token, []error) {
+	s := NewScanner(file, strings.NewReader(text))
+	s.Strict = true
+	return lex(s)
+}
+
+func lex(s *Scanner) ([]Token, []error) {
+	var res []Token
+	var errs []error
+	for {
+		t, err := s.Next()
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		res = append(res, t)
+		if t.tt == Eof {
+			return res, errs
+		}
+	}
+}
+
+// Scanner reads tokens from a reader one at a time, so big sources never have
+// to be in memory at once
+type Scanner struct {
+	// Strict only accepts keywords made of two words when they are written
+	// like in GEB: upper case and separated by a single space, like END IF
+	Strict bool
+
+	reader  *bufio.Reader
+	current ast.Pos
+	// buf keeps the text read since the last mark, which is at offset start
+	// of the source
+	buf   []byte
+	start int
+	// err is the error that stopped the reader, if it wasn't io.EOF
+	err error
+}
+
+// NewScanner returns a scanner over the source of the named file
+func NewScanner(file string, r io.Reader) *Scanner {
+	return &Scanner{
+		reader:  bufio.NewReader(r),
+		current: ast.Pos{File: file, Line: 1, Column: 1},
+	}
+}
+
+// Next returns the next token of the source along with the comments before
+// it. Text that can't be lexed is returned as an Illegal token together with
+// the error describing it, and scanning can go on after it. Once the source
+// ends every call returns an Eof token
+func (s *Scanner) Next() (Token, error) {
+	s.mark()
+	comments, err := s.trivia()
+	if s.isAtEnd() {
+		if err == nil {
+			err, s.err = s.err, nil
+		}
+
+		eof := token(Eof, "", s.current, s.current)
+		eof.comments = comments
+		return eof, err
+	}
+
+	start := s.mark()
+	t, err := s.token(start)
+	if err != nil {
+		t = token(Illegal, s.lexeme(start), start, s.current)
+	}
+	t.comments = append(comments, t.comments...)
+	return t, err
+}
+
+// peekRunes decodes up to two runes ahead of the current position
+func (s *Scanner) peekRunes() (rune, rune) {
+	b, err := s.reader.Peek(2 * utf8.UTFMax)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull && s.err == nil {
+		s.err = err
+	}
+
+	if len(b) == 0 {
+		return utf8.RuneError, utf8.RuneError
+	}
+
+	first, size := utf8.DecodeRune(b)
+	if len(b) == size {
+		return first, utf8.RuneError
+	}
+
+	second, _ := utf8.DecodeRune(b[size:])
+	return first, second
+}
+
+func (s *Scanner) isAtEnd() bool {
+	b, _ := s.reader.Peek(1)
+	return len(b) == 0
+}
+
+func (s *Scanner) peek() rune {
+	r, _ := s.peekRunes()
+	return r
+}
+
+func (s *Scanner) advance() rune {
+	b, _ := s.reader.Peek(utf8.UTFMax)
+	r, size := utf8.DecodeRune(b)
+	s.buf = append(s.buf, b[:size]...)
+	s.reader.Discard(size)
+
+	s.current.Offset += size
+	if r == '\n' {
+		s.current.Line++
+		s.current.Column = 1
+	} else {
+		s.current.Column++
+	}
+	return r
+}
+
+// mark forgets the text read so far and returns the current position, where
+// the next token starts
+func (s *Scanner) mark() ast.Pos {
+	s.buf, s.start = s.buf[:0], s.current.Offset
+	return s.current
+}
+
+func (s *Scanner) skipWhitespace() {
+	for !s.isAtEnd() && isSpace(s.peek()) {
+		s.advance()
+	}
+}
+
+// trivia skips the whitespace and comments before the next token and returns
+// the comments. Line comments start with # and block comments go between
+// braces: { like this }
+func (s *Scanner) trivia() ([]Comment, error) {
+	var comments []Comment
+	for {
+		s.skipWhitespace()
+		start := s.current
+		switch s.peek() {
+		case '#':
+			for !s.isAtEnd() && s.peek() != '\n' {
+				s.advance()
+			}
+		case '{':
+			s.advance()
+			open := s.current
+			for !s.isAtEnd() && s.peek() != '}' {
+				s.advance()
+			}
+
+			if s.isAtEnd() {
+				comments = append(comments, Comment{Text: s.lexeme(start), Span: ast.Span{Start: start, End: s.current}})
+				return comments, unterminatedCommentErr(ast.Span{Start: start, End: open})
+			}
+			s.advance()
+		default:
+			return comments, nil
+		}
+
+		comments = append(comments, Comment{Text: s.lexeme(start), Span: ast.Span{Start: start, End: s.current}})
+	}
+}
+
+// lexeme returns the text between the given position, which can't be before
+// the last mark, and the current one
+func (s *Scanner) lexeme(start ast.Pos) string {
+	return string(s.buf[start.Offset-s.start:])
+}
+
+// word lexes letters and digits. A hyphen followed by a letter joins two
+// words, so TWO-TO-THE-THREE-TO-THE is a single name while N - 1 keeps the
+// hyphen apart
+func (s *Scanner) word() {
+	for !s.isAtEnd() {
+		r, next := s.peekRunes()
+		if !isLetter(r) && !isNumber(r) && (r != '-' || !isLetter(next)) {
+			break
+		}
+		s.advance()
+	}
+}
+
+func (s *Scanner) token(start ast.Pos) (Token, error) {
+	r := s.advance()
+	switch r {
+	case '"':
+		return s.quotedName(start)
+	case '+':
+		return token(Plus, s.lexeme(start), start, s.current), nil
+	case '*':
+		return token(Star, s.lexeme(start), start, s.current), nil
+	case '=':
+		return token(Equal, s.lexeme(start), start, s.current), nil
+	case '<':
+		switch s.peek() {
+		case '=':
+			s.advance()
+			return token(LesserEqual, s.lexeme(start), start, s.current), nil
+		case '-':
+			s.advance()
+			return token(LeftArrow, s.lexeme(start), start, s.current), nil
+		}
+		return token(Lesser, s.lexeme(start), start, s.current), nil
+	case '>':
+		if s.peek() == '=' {
+			s.advance()
+			return token(GreaterEqual, s.lexeme(start), start, s.current), nil
+		}
+		return token(Greater, s.lexeme(start), start, s.current), nil
+	case '(':
+		return token(LeftParen, s.lexeme(start), start, s.current), nil
+	case ')':
+		return token(RightParen, s.lexeme(start), start, s.current), nil
+	case '[':
+		return token(LeftSquareBracket, s.lexeme(start), start, s.current), nil
+	case ']':
+		return token(RightSquareBracket, s.lexeme(start), start, s.current), nil
+	case ',':
+		return token(Comma, s.lexeme(start), start, s.current), nil
+	}
+
+	if isNumber(r) {
+		for !s.isAtEnd() && isNumber(s.peek()) {
+			s.advance()
+		}
+
+		lexeme := s.lexeme(start)
+		if value, err := strconv.ParseInt(lexeme, 10, 64); err == nil {
+			return constant(lexeme, value, start, s.current), nil
+		}
+		value, _ := new(big.Int).SetString(lexeme, 10)
+		return constant(lexeme, value, start, s.current), nil
+	}
+
+	if isLetter(r) {
+		return s.keywordOrIdentifier(start)
+	}
+
+	// The whole run of unexpected characters is reported at once
+	for !s.isAtEnd() && !startsToken(s.peek()) {
+		s.advance()
+	}
+	return Token{}, unexpectedCharacterErr(ast.Span{Start: start, End: s.current}, s.lexeme(start))
+}
+
+// quotedName lexes a procedure name between double quotes. Names can't span
+// lines and are upper cased, so "Minus" and MINUS name the same procedure
+func (s *Scanner) quotedName(start ast.Pos) (Token, error) {
+	for !s.isAtEnd() && s.peek() != '"' && s.peek() != '\n' {
+		s.advance()
+	}
+
+	if s.isAtEnd() || s.peek() != '"' {
+		return Token{}, unterminatedNameErr(ast.Span{Start: start, End: s.current})
+	}
+	s.advance()
+
+	lexeme := s.lexeme(start)
+	name := lexeme[1 : len(lexeme)-1]
+	span := ast.Span{Start: start, End: s.current}
+	if !isName(name) {
+		return Token{}, invalidProcedureNameErr(span, name)
+	}
+
+	if isReserved(name) {
+		return Token{}, reservedNameErr(span, name)
+	}
+	return quotedName(lexeme, strings.ToUpper(name), start, s.current), nil
+}
+
+// isName reports whether the text is a name like the ones word lexes,
+// optionally ending with a question mark: words of letters and digits joined
+// by hyphens, every word starting with a letter, like TWIN-PRIME?
+func isName(name string) bool {
+	name = strings.TrimSuffix(name, "?")
+	for _, word := range strings.Split(name, "-") {
+		if first, _ := utf8.DecodeRuneInString(word); !isLetter(first) {
+			return false
+		}
+
+		for _, r := range word {
+			if !isLetter(r) && !isNumber(r) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isReserved reports whether the name is a keyword, a word starting a multi
+// word keyword, YES, NO or OUTPUT. None of them can name a procedure
+func isReserved(name string) bool {
+	if _, ok := multiWordKeywords[strings.ToUpper(name)]; ok {
+		return true
+	}
+	_, ok := reserved(name, ast.Pos{}, ast.Pos{})
+	return ok
+}
+
+// multiWordKeywords maps the first word of every keyword made of two words to
+// the words that can follow it. The words can be written in any case, with
+// any whitespace or comments between them, or together as a single word like
+// ENDIF, unless the scanner is strict
+var multiWordKeywords = map[string][]string{
+	"END":    {"IF", "LOOP", "PROCEDURE"},
+	"DEFINE": {"PROCEDURE"},
+	"QUIT":   {"PROCEDURE"},
+	"ABORT":  {"LOOP"},
+}
+
+// multiWordKeyword returns how a keyword written as a single word, like
+// ENDIF, is written in GEB
+func multiWordKeyword(word string) (string, bool) {
+	for first, words := range multiWordKeywords {
+		for _, second := range words {
+			if word == first+second {
+				return first + " " + second, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (s *Scanner) keywordOrIdentifier(start ast.Pos) (Token, error) {
+	s.word()
+	lexeme := s.lexeme(start)
+	first := strings.ToUpper(lexeme)
+
+	if words, ok := multiWordKeywords[first]; ok {
+		comments, err := s.trivia()
+		if err != nil {
+			return Token{}, err
+		}
+
+		second := s.current
+		s.word()
+		word := strings.ToUpper(s.lexeme(second))
+
+		valid := false
+		expected := make([]string, len(words))
+		for i, w := range words {
+			valid = valid || word == w
+			expected[i] = "'" + strings.ToLower(first+" "+w) + "'"
+		}
+
+		if !valid {
+			return Token{}, expectedKeywordErr(ast.Span{Start: second, End: s.current}, strings.Join(expected, " or "))
+		}
+
+		keyword := first + " " + word
+		if s.Strict && s.lexeme(start) != keyword {
+			return Token{}, nonStandardKeywordErr(ast.Span{Start: start, End: s.current}, keyword)
+		}
+
+		t, _ := reserved(first+word, start, s.current)
+		t.comments = comments
+		return t, nil
+	}
+
+	if t, ok := reserved(lexeme, start, s.current); ok {
+		if keyword, ok := multiWordKeyword(first); ok && s.Strict {
+			return Token{}, nonStandardKeywordErr(t.Span(), keyword)
+		}
+		return t, nil
+	}
+
+	if s.peek() == '?' {
+		s.advance()
+		lexeme = s.lexeme(start)
+	}
+
+	// Names are case insensitive, like keywords
+	return identifier(lexeme, strings.ToUpper(lexeme), start, s.current), nil
+}
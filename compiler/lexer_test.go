@@ -0,0 +1,539 @@
+package compiler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gonzispina/gloop/ast"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestLexer(t *testing.T) {
+	t.Run("It returns the correct tokens for number procedure", func(t *testing.T) {
+		text := `
+			DEFINE PROCEDURE "MINUS" [M, N]
+				IF M < N THEN
+					QUIT PROCEDURE
+				END IF
+				LOOP M + 1 TIMES
+					IF OUTPUT + N = M THEN
+						ABORT LOOP
+					END IF
+					OUTPUT <- OUTPUT + 1
+				END LOOP
+			END PROCEDURE
+		`
+
+		expected := []Token{
+			{tt: DefineProcedure},
+			{tt: QuotedName, value: "MINUS"},
+			{tt: LeftSquareBracket},
+			{tt: Identifier, value: "M"},
+			{tt: Comma},
+			{tt: Identifier, value: "N"},
+			{tt: RightSquareBracket},
+			{tt: If},
+			{tt: Identifier, value: "M"},
+			{tt: Lesser},
+			{tt: Identifier, value: "N"},
+			{tt: Then},
+			{tt: QuitProcedure},
+			{tt: EndIf},
+			{tt: Loop},
+			{tt: Identifier, value: "M"},
+			{tt: Plus},
+			{tt: Constant, value: int64(1)},
+			{tt: Times},
+			{tt: If},
+			{tt: Identifier},
+			{tt: Plus},
+			{tt: Identifier, value: "N"},
+			{tt: Equal},
+			{tt: Identifier, value: "M"},
+			{tt: Then},
+			{tt: AbortLoop},
+			{tt: EndIf},
+			{tt: Identifier},
+			{tt: LeftArrow},
+			{tt: Identifier},
+			{tt: Plus},
+			{tt: Constant, value: int64(1)},
+			{tt: EndLoop},
+			{tt: EndProcedure},
+			{tt: Eof},
+		}
+
+		res, errs := Lexer(text)
+		assert.Nil(t, errs)
+		for i, tkn := range res {
+			assert.Equal(t, expected[i].tt, tkn.tt)
+		}
+	})
+
+	t.Run("It returns the correct tokens for test procedure", func(t *testing.T) {
+		text := `
+			DEFINE PROCEDURE "ISEVEN?" [N]
+				IF N < 2 THEN
+					OUTPUT <- YES
+					QUIT PROCEDURE
+				END IF
+
+				LOOP N TIMES 
+					N <- MINUS[N, 2]
+					IF N = 1 THEN
+						OUTPUT <- NO
+						QUIT PROCEDURE
+					ELSE IF N = 0 THEN
+						OUTPUT <- YES
+						QUIT PROCEDURE
+					END IF
+				END LOOP
+			END PROCEDURE
+		`
+
+		expected := []Token{
+			{tt: DefineProcedure},
+			{tt: QuotedName, value: "ISEVEN?"},
+			{tt: LeftSquareBracket},
+			{tt: Identifier, value: "N"},
+			{tt: RightSquareBracket},
+
+			{tt: If},
+			{tt: Identifier, value: "N"},
+			{tt: Lesser},
+			{tt: Constant, value: int64(2)},
+			{tt: Then},
+			{tt: Identifier},
+			{tt: LeftArrow},
+			{tt: Constant, value: true},
+			{tt: QuitProcedure},
+			{tt: EndIf},
+
+			{tt: Loop},
+			{tt: Identifier, value: "N"},
+			{tt: Times},
+
+			{tt: Identifier, value: "N"},
+			{tt: LeftArrow},
+			{tt: Identifier, value: "MINUS"},
+			{tt: LeftSquareBracket},
+			{tt: Identifier, value: "N"},
+			{tt: Comma},
+			{tt: Constant, value: int64(2)},
+			{tt: RightSquareBracket},
+
+			{tt: If},
+			{tt: Identifier, value: "N"},
+			{tt: Equal},
+			{tt: Constant, value: int64(1)},
+			{tt: Then},
+			{tt: Identifier},
+			{tt: LeftArrow},
+			{tt: Constant, value: false},
+			{tt: QuitProcedure},
+
+			{tt: Else},
+			{tt: If},
+			{tt: Identifier, value: "N"},
+			{tt: Equal},
+			{tt: Constant, value: int64(0)},
+			{tt: Then},
+			{tt: Identifier},
+			{tt: LeftArrow},
+			{tt: Constant, value: true},
+			{tt: QuitProcedure},
+			{tt: EndIf},
+
+			{tt: EndLoop},
+			{tt: EndProcedure},
+			{tt: Eof},
+		}
+
+		res, errs := Lexer(text)
+		assert.Nil(t, errs)
+		for i, tkn := range res {
+			assert.Equal(t, expected[i].tt, tkn.tt)
+		}
+	})
+
+	t.Run("Every token knows where it starts and ends", func(t *testing.T) {
+		text := "\n\n  N <- 12\n\tOUTPUT <- N >= 3"
+
+		res, errs := Lex("even.bloop", text)
+		require.Nil(t, errs)
+
+		expected := []struct {
+			lexeme string
+			line   int
+			column int
+		}{
+			{"N", 3, 3},
+			{"<-", 3, 5},
+			{"12", 3, 8},
+			{"OUTPUT", 4, 2},
+			{"<-", 4, 9},
+			{"N", 4, 12},
+			{">=", 4, 14},
+			{"3", 4, 17},
+			{"", 4, 18},
+		}
+
+		require.Equal(t, len(expected), len(res))
+		for i, e := range expected {
+			assert.Equal(t, e.lexeme, res[i].lexeme)
+			assert.Equal(t, "even.bloop", res[i].Pos().File)
+			assert.Equal(t, e.line, res[i].Pos().Line, e.lexeme)
+			assert.Equal(t, e.column, res[i].Pos().Column, e.lexeme)
+			assert.Equal(t, e.lexeme, text[res[i].Pos().Offset:res[i].End().Offset])
+		}
+		assert.Equal(t, Eof, res[len(res)-1].tt)
+	})
+
+	t.Run("Columns count runes and offsets count bytes", func(t *testing.T) {
+		res, errs := Lexer(`"ÑANDÚ" <- 1`)
+		require.Nil(t, errs)
+
+		assert.Equal(t, ast.Pos{Line: 1, Column: 1, Offset: 0}, res[0].Pos())
+		assert.Equal(t, ast.Pos{Line: 1, Column: 8, Offset: 9}, res[0].End())
+		assert.Equal(t, ast.Pos{Line: 1, Column: 9, Offset: 10}, res[1].Pos())
+	})
+
+	t.Run("Multi word keywords span both words", func(t *testing.T) {
+		text := "END\n  IF"
+		res, errs := Lexer(text)
+		require.Nil(t, errs)
+
+		assert.Equal(t, EndIf, res[0].tt)
+		assert.Equal(t, 1, res[0].Pos().Line)
+		assert.Equal(t, ast.Pos{Line: 2, Column: 5, Offset: len(text)}, res[0].End())
+	})
+
+	t.Run("Errors point at the offending character", func(t *testing.T) {
+		_, errs := Lex("bad.bloop", "N <- 1\nN <- N $ 2")
+		require.Equal(t, 1, len(errs))
+
+		var compileErr *Diagnostic
+		require.ErrorAs(t, errs[0], &compileErr)
+		assert.ErrorIs(t, errs[0], UnexpectedCharacterErrCode)
+		assert.Equal(t, "bad.bloop:2:8", compileErr.Span.Start.String())
+		assert.Equal(t, "bad.bloop:2:9", compileErr.Span.End.String())
+	})
+
+	t.Run("Every error is reported and lexing goes on after it", func(t *testing.T) {
+		text := "N <- 1 $ 2\nEND FOO\nM <- N @@@ 3\nOUTPUT <- M"
+		res, errs := Lex("bad.bloop", text)
+		require.Equal(t, 3, len(errs))
+
+		assert.ErrorIs(t, errs[0], UnexpectedCharacterErrCode)
+		assert.ErrorIs(t, errs[1], ExpectedKeywordErrCode)
+		assert.ErrorIs(t, errs[2], UnexpectedCharacterErrCode)
+		assert.Contains(t, errs[2].Error(), "bad.bloop:3:8: unexpected characters '@@@'")
+
+		var illegal []string
+		for _, tkn := range res {
+			if tkn.tt == Illegal {
+				illegal = append(illegal, tkn.lexeme)
+			}
+		}
+		assert.Equal(t, []string{"$", "END FOO", "@@@"}, illegal)
+
+		last := res[len(res)-2]
+		assert.Equal(t, Identifier, last.tt)
+		assert.Equal(t, "M", last.lexeme)
+		assert.Equal(t, Eof, res[len(res)-1].tt)
+	})
+
+	t.Run("Comments are kept on the token that follows them", func(t *testing.T) {
+		text := "# MINUS.BLOOP\n{ Chapter XIII,\n  page 410 }\nN <- 1 # one\nOUTPUT <- N {done}"
+		res, errs := Lexer(text)
+		require.Nil(t, errs)
+		require.Equal(t, 7, len(res))
+
+		require.Equal(t, 2, len(res[0].Comments()))
+		assert.Equal(t, "# MINUS.BLOOP", res[0].Comments()[0].Text)
+		assert.Equal(t, "{ Chapter XIII,\n  page 410 }", res[0].Comments()[1].Text)
+		assert.Equal(t, ast.Span{
+			Start: ast.Pos{Line: 2, Column: 1, Offset: 14},
+			End:   ast.Pos{Line: 3, Column: 13, Offset: 42},
+		}, res[0].Comments()[1].Span)
+
+		assert.Equal(t, []Comment{{Text: "# one", Span: ast.Span{
+			Start: ast.Pos{Line: 4, Column: 8, Offset: 50},
+			End:   ast.Pos{Line: 4, Column: 13, Offset: 55},
+		}}}, res[3].Comments())
+		assert.Equal(t, "OUTPUT", res[3].lexeme)
+
+		assert.Equal(t, Eof, res[6].tt)
+		require.Equal(t, 1, len(res[6].Comments()))
+		assert.Equal(t, "{done}", res[6].Comments()[0].Text)
+	})
+
+	t.Run("Comments end runs of unexpected characters", func(t *testing.T) {
+		res, errs := Lexer("N <- 1 $$# comment")
+		require.Equal(t, 1, len(errs))
+		assert.Contains(t, errs[0].Error(), "'$$'")
+		assert.Equal(t, "# comment", res[len(res)-1].Comments()[0].Text)
+	})
+
+	t.Run("Block comments must be closed", func(t *testing.T) {
+		_, errs := Lexer("N <- 1\n{ never closed\nOUTPUT <- N")
+		require.Equal(t, 1, len(errs))
+		assert.ErrorIs(t, errs[0], UnterminatedCommentErrCode)
+		assert.Contains(t, errs[0].Error(), "Line 2 Column 1")
+	})
+
+	t.Run("Quoted procedure names are their own upper cased token", func(t *testing.T) {
+		res, errs := Lexer(`"Twin-Prime?" "TWO-TO-THE-THREE-TO-THE" "V2"`)
+		require.Nil(t, errs)
+
+		assert.Equal(t, QuotedName, res[0].tt)
+		assert.Equal(t, `"Twin-Prime?"`, res[0].lexeme)
+		assert.Equal(t, "TWIN-PRIME?", res[0].value)
+		assert.Equal(t, "TWO-TO-THE-THREE-TO-THE", res[1].value)
+		assert.Equal(t, "V2", res[2].value)
+	})
+
+	t.Run("Quoted procedure names only have letters, digits, hyphens and a final question mark", func(t *testing.T) {
+		for _, name := range []string{`""`, `"TWO TO"`, `"-A"`, `"A-"`, `"A--B"`, `"2A"`, `"A?B"`, `"A??"`, `"A_B"`, `"A+B"`} {
+			_, errs := Lexer(name)
+			require.Equal(t, 1, len(errs), name)
+			assert.ErrorIs(t, errs[0], InvalidProcedureNameErrCode, name)
+		}
+	})
+
+	t.Run("Quoted procedure names must be closed on the same line", func(t *testing.T) {
+		res, errs := Lexer("DEFINE PROCEDURE \"MINUS [M, N]\nEND PROCEDURE")
+		require.Equal(t, 1, len(errs))
+		assert.ErrorIs(t, errs[0], UnterminatedNameErrCode)
+		assert.Equal(t, `"MINUS [M, N]`, res[1].lexeme)
+		assert.Equal(t, EndProcedure, res[2].tt)
+
+		_, errs = Lexer(`OUTPUT <- "MINUS`)
+		require.Equal(t, 1, len(errs))
+		assert.ErrorIs(t, errs[0], UnterminatedNameErrCode)
+	})
+
+	t.Run("Hyphens join words of a name when a letter follows them", func(t *testing.T) {
+		res, errs := Lexer("TWO-TO-THE-THREE-TO-THE[N] twin-prime?[n]")
+		require.Nil(t, errs)
+
+		assert.Equal(t, Identifier, res[0].tt)
+		assert.Equal(t, "TWO-TO-THE-THREE-TO-THE", res[0].value)
+		assert.Equal(t, Identifier, res[4].tt)
+		assert.Equal(t, "twin-prime?", res[4].lexeme)
+		assert.Equal(t, "TWIN-PRIME?", res[4].value)
+		assert.Equal(t, "N", res[6].value)
+
+		res, errs = Lexer("N - M N-1 N-")
+		require.Equal(t, 3, len(errs))
+		assert.Equal(t, []string{"N", "-", "M", "N", "-", "1", "N", "-"}, lexemes(res))
+	})
+
+	t.Run("Names are upper cased like keywords", func(t *testing.T) {
+		res, errs := Lexer("output <- Output + cell(0) + Ñandú")
+		require.Nil(t, errs)
+
+		assert.Equal(t, "OUTPUT", res[0].value)
+		assert.Equal(t, "OUTPUT", res[2].value)
+		assert.Equal(t, Cell, res[4].tt)
+		assert.Equal(t, "ÑANDÚ", res[9].value)
+	})
+
+	t.Run("Reserved words can't name procedures", func(t *testing.T) {
+		for _, name := range []string{`"IF"`, `"Times"`, `"cell"`, `"OUTPUT"`, `"YES"`, `"END"`, `"ENDIF"`, `"ABORT"`} {
+			_, errs := Lexer(name)
+			require.Equal(t, 1, len(errs), name)
+			assert.ErrorIs(t, errs[0], ReservedNameErrCode, name)
+		}
+
+		_, errs := Lexer(`"END-IF" "IF?" "OUTPUTS"`)
+		assert.Nil(t, errs)
+	})
+}
+
+func lexemes(tokens []Token) []string {
+	var res []string
+	for _, t := range tokens {
+		if t.tt != Eof {
+			res = append(res, t.lexeme)
+		}
+	}
+	return res
+}
+
+func TestLexer_MultiWordKeywords(t *testing.T) {
+	keywords := []struct {
+		first  string
+		second string
+		tt     tokenType
+	}{
+		{"END", "IF", EndIf},
+		{"END", "LOOP", EndLoop},
+		{"END", "PROCEDURE", EndProcedure},
+		{"DEFINE", "PROCEDURE", DefineProcedure},
+		{"QUIT", "PROCEDURE", QuitProcedure},
+		{"ABORT", "LOOP", AbortLoop},
+	}
+
+	variants := []struct {
+		name   string
+		write  func(first string, second string) string
+		strict bool
+	}{
+		{"separated by a space", func(f, s string) string { return f + " " + s }, true},
+		{"as a single word", func(f, s string) string { return f + s }, false},
+		{"in title case", func(f, s string) string { return f[:1] + strings.ToLower(f[1:]) + " " + s[:1] + strings.ToLower(s[1:]) }, false},
+		{"in lower case", func(f, s string) string { return strings.ToLower(f + " " + s) }, false},
+		{"separated by a new line", func(f, s string) string { return f + "\n" + s }, false},
+		{"separated by any whitespace", func(f, s string) string { return f + " \t\r\n\n  " + s }, false},
+		{"separated by a line comment", func(f, s string) string { return f + " # comment\n" + s }, false},
+		{"separated by a block comment", func(f, s string) string { return f + "{ comment }" + s }, false},
+	}
+
+	for _, k := range keywords {
+		for _, v := range variants {
+			text := v.write(k.first, k.second)
+
+			t.Run(k.first+" "+k.second+" "+v.name, func(t *testing.T) {
+				res, errs := Lexer(text + " N")
+				require.Nil(t, errs, text)
+				require.Equal(t, 3, len(res), text)
+				assert.Equal(t, k.tt, res[0].tt, text)
+				assert.Equal(t, k.first+k.second, res[0].lexeme, text)
+				assert.Equal(t, ast.Pos{Line: 1, Column: 1, Offset: 0}, res[0].Pos(), text)
+				assert.Equal(t, len(text), res[0].End().Offset, text)
+				assert.Equal(t, "N", res[1].value, text)
+
+				if strings.Contains(text, "comment") {
+					require.Equal(t, 1, len(res[0].Comments()))
+					assert.Contains(t, res[0].Comments()[0].Text, "comment")
+				}
+			})
+
+			t.Run(k.first+" "+k.second+" "+v.name+" in strict mode", func(t *testing.T) {
+				s := NewScanner("", strings.NewReader(text))
+				s.Strict = true
+
+				tkn, err := s.Next()
+				if v.strict {
+					require.Nil(t, err, text)
+					assert.Equal(t, k.tt, tkn.tt, text)
+					return
+				}
+
+				require.NotNil(t, err, text)
+				assert.ErrorIs(t, err, NonStandardKeywordErrCode, text)
+				assert.Equal(t, Illegal, tkn.tt)
+				assert.Equal(t, text, tkn.lexeme)
+
+				var d *Diagnostic
+				require.ErrorAs(t, err, &d)
+				assert.Equal(t, k.first+" "+k.second, d.Fix.Replacement)
+
+				tkn, err = s.Next()
+				assert.Nil(t, err)
+				assert.Equal(t, Eof, tkn.tt)
+			})
+		}
+	}
+
+	t.Run("A first word without its second one is an error", func(t *testing.T) {
+		for _, text := range []string{"END", "END N", "DEFINE LOOP", "ABORT\n", "QUIT # PROCEDURE"} {
+			_, errs := Lexer(text)
+			require.Equal(t, 1, len(errs), text)
+			assert.ErrorIs(t, errs[0], ExpectedKeywordErrCode, text)
+		}
+	})
+}
+
+// countingReader counts the bytes read from it
+type countingReader struct {
+	r    io.Reader
+	read int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.read += n
+	return n, err
+}
+
+func TestScanner(t *testing.T) {
+	t.Run("It returns the same tokens as Lex", func(t *testing.T) {
+		text := "# ÑANDÚ\nDEFINE PROCEDURE \"TWIN-PRIME?\" [N]\n\tOUTPUT <- N + 123456789012345678901234567890 { big }\nEND PROCEDURE $"
+		expected, errs := Lex("a.bloop", text)
+		require.Equal(t, 1, len(errs))
+
+		// One byte at a time splits the runes between reads
+		s := NewScanner("a.bloop", iotest.OneByteReader(strings.NewReader(text)))
+		for i := range expected {
+			tkn, err := s.Next()
+			assert.Equal(t, expected[i], tkn)
+			assert.Equal(t, tkn.tt == Illegal, err != nil)
+		}
+
+		tkn, err := s.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, Eof, tkn.tt)
+	})
+
+	t.Run("Tokens are read lazily", func(t *testing.T) {
+		r := &countingReader{r: strings.NewReader("N <- 1\n" + strings.Repeat("N <- N + 1\n", 100000))}
+		s := NewScanner("", r)
+
+		for i := 0; i < 3; i++ {
+			_, err := s.Next()
+			require.Nil(t, err)
+		}
+		assert.Less(t, r.read, 10000)
+	})
+
+	t.Run("Reader errors are returned with the Eof token", func(t *testing.T) {
+		failure := errors.New("disk on fire")
+		s := NewScanner("", io.MultiReader(strings.NewReader("N <- 1"), iotest.ErrReader(failure)))
+
+		var tokens []Token
+		for {
+			tkn, err := s.Next()
+			tokens = append(tokens, tkn)
+			if tkn.tt == Eof {
+				assert.Equal(t, failure, err)
+				break
+			}
+			require.Nil(t, err)
+		}
+		assert.Equal(t, 4, len(tokens))
+	})
+
+	t.Run("Big generated files keep accurate positions", func(t *testing.T) {
+		var b strings.Builder
+		lines := 50000
+		for i := 0; i < lines; i++ {
+			fmt.Fprintf(&b, "N%d <- %d # line %d\n", i, i, i+1)
+		}
+
+		res, errs := Lex("big.bloop", b.String())
+		require.Nil(t, errs)
+		require.Equal(t, 3*lines+1, len(res))
+
+		last := res[len(res)-2]
+		assert.Equal(t, fmt.Sprint(lines-1), last.lexeme)
+		assert.Equal(t, lines, last.Pos().Line)
+		assert.Equal(t, b.String()[last.Pos().Offset:last.End().Offset], last.lexeme)
+		assert.Equal(t, fmt.Sprintf("# line %d", lines), res[len(res)-1].Comments()[0].Text)
+	})
+}
+
+func BenchmarkLex(b *testing.B) {
+	text := strings.Repeat("DEFINE PROCEDURE \"TWO-TO-THE-THREE-TO-THE\" [N]\n\tOUTPUT <- CELL(0) + N * 2 # comment\nEND PROCEDURE\n", 10000)
+	b.SetBytes(int64(len(text)))
+	for i := 0; i < b.N; i++ {
+		if _, errs := Lex("", text); errs != nil {
+			b.Fatal(errs)
+		}
+	}
+}
@@ -1,182 +1,499 @@
 package compiler
 
-import (
-	"errors"
-	"fmt"
-	"strconv"
-	"strings"
-)
+import "github.com/gonzispina/gloop/ast"
 
-func isNumber(s string) bool {
-	return s[0] >= '0' && s[0] <= '9'
+var operators = map[tokenType]ast.Operator{
+	Plus:         ast.Add,
+	Star:         ast.Multiply,
+	Equal:        ast.Equal,
+	Lesser:       ast.Lesser,
+	LesserEqual:  ast.LesserEqual,
+	Greater:      ast.Greater,
+	GreaterEqual: ast.GreaterEqual,
+	And:          ast.And,
+	Or:           ast.Or,
+	Not:          ast.Not,
 }
 
-func isLetter(s string) bool {
-	return s[0] >= 'a' && s[0] <= 'z' || s[0] >= 'A' && s[0] <= 'Z' || s[0] == '_'
+// Parse builds the syntax tree of a program. Every procedure reports its own
+// errors, so more than one error may be returned
+func Parse(tokens []Token) (*ast.Program, []error) {
+	return newParser(tokens).program()
 }
 
-func Lexer(text string) ([]Token, error) {
-	var res []Token
-	var i, line int
+// ParseInput parses the tokens of a REPL input, which may be a program or a
+// bare expression. Only one of the first two results is set
+func ParseInput(tokens []Token) (*ast.Program, ast.Expression, []error) {
+	p := newParser(tokens)
+	if !p.isExpression() {
+		program, errs := p.program()
+		return program, nil, errs
+	}
+
+	e, err := p.bareExpression()
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+	return nil, e, nil
+}
 
-	text = strings.Trim(text, " ")
-	text = strings.Trim(text, "\t")
-	text = strings.Trim(text, "\n")
+type parser struct {
+	tokens  []Token
+	counter int
+}
 
-	isAtEnd := func() bool {
-		return i >= len(text)
+func newParser(tokens []Token) *parser {
+	return &parser{tokens: tokens}
+}
+
+func (p *parser) isAtEnd() bool {
+	return p.counter >= len(p.tokens) || p.tokens[p.counter].tt == Eof
+}
+
+func (p *parser) advance() Token {
+	if p.isAtEnd() {
+		return p.eof()
 	}
+	p.counter++
+	return p.tokens[p.counter-1]
+}
 
-	current := func() string {
-		return string(text[i])
+func (p *parser) peek() Token {
+	if p.isAtEnd() {
+		return p.eof()
 	}
+	return p.tokens[p.counter]
+}
 
-	next := func() string {
-		l := current()
-		i++
+// previous returns the last token consumed
+func (p *parser) previous() Token {
+	if p.counter == 0 {
+		return p.peek()
+	}
+	return p.tokens[p.counter-1]
+}
 
-		for !isAtEnd() && (l == " " || l == "\n" || l == "\t") {
-			if l == "\n" {
-				line++
-			}
+func (p *parser) eof() Token {
+	if len(p.tokens) > 0 && p.tokens[len(p.tokens)-1].tt == Eof {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return Token{tt: Eof}
+}
+
+func (p *parser) match(tt tokenType) bool {
+	t := p.peek()
+	if t.tt == tt {
+		p.counter++
+		return true
+	}
+	return false
+}
+
+func (p *parser) parsePrecedence(previous Token, precedence Precedence) (ast.Expression, error) {
+	prefixRule := getRule(previous.tt).prefix
+	if prefixRule == nil {
+		return nil, expectedExpressionErr(previous.Span())
+	}
 
-			l = current()
-			i++
+	e, err := prefixRule(p)
+	if err != nil {
+		return nil, err
+	}
+
+	for rule := getRule(p.peek().tt); rule.precedence > precedence; rule = getRule(p.peek().tt) {
+		e, err = rule.infix(p, e)
+		if err != nil {
+			return nil, err
 		}
-		return l
 	}
 
-	for !isAtEnd() {
-		letter := next()
-		switch letter {
-		case "\"":
-			lexeme := next()
-			for !isAtEnd() && current() != "\"" {
-				lexeme += next()
+	return e, nil
+}
+
+func (p *parser) expression() (ast.Expression, error) {
+	return p.parsePrecedence(p.peek(), precedenceAssigment)
+}
+
+func (p *parser) literal() (ast.Expression, error) {
+	t := p.advance()
+	return &ast.Literal{Position: t.Pos(), Value: t.value, End: t.End()}, nil
+}
+
+func (p *parser) unary() (ast.Expression, error) {
+	t := p.advance()
+	operand, err := p.parsePrecedence(p.peek(), precedenceUnary)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ast.Unary{Operator: operators[t.tt], OpPos: t.Pos(), Operand: operand}, nil
+}
+
+// binary parses the right operand of every infix operator. Operators of the
+// same precedence are left to the loop in parsePrecedence, which makes them
+// left associative
+func (p *parser) binary(left ast.Expression) (ast.Expression, error) {
+	t := p.advance()
+	rule := getRule(t.tt)
+	right, err := p.parsePrecedence(p.peek(), rule.precedence)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ast.Binary{Operator: operators[t.tt], OpPos: t.Pos(), Left: left, Right: right}, nil
+}
+
+func (p *parser) grouping() (ast.Expression, error) {
+	t := p.advance()
+	e, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+
+	end := p.peek()
+	if !p.match(RightParen) {
+		return nil, expectedRightParenthesisErr(end.Span())
+	}
+
+	return &ast.Grouping{Position: t.Pos(), Expression: e, End: end.End()}, nil
+}
+
+func (p *parser) variable() (ast.Expression, error) {
+	t := p.advance()
+	if p.peek().tt == LeftSquareBracket {
+		return p.call(t)
+	}
+
+	if t.tt == QuotedName {
+		return nil, expectedArgumentsErr(p.peek().Span())
+	}
+
+	return &ast.VarRef{Position: t.Pos(), Name: t.value.(string), End: t.End()}, nil
+}
+
+func (p *parser) call(t Token) (ast.Expression, error) {
+	p.advance()
+
+	n := &ast.Call{Position: t.Pos(), Name: t.value.(string)}
+	for end := p.peek(); !p.match(RightSquareBracket); end = p.peek() {
+		if len(n.Args) > 0 && !p.match(Comma) {
+			return nil, expectedArgumentsErr(end.Span())
+		}
+
+		arg, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		n.Args = append(n.Args, arg)
+	}
+
+	n.End = p.previous().End()
+	return n, nil
+}
+
+func (p *parser) cell() (ast.Expression, error) {
+	t := p.advance()
+	if !p.match(LeftParen) {
+		return nil, expectedCellIndexErr(t.Span())
+	}
+
+	index, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+
+	end := p.peek()
+	if !p.match(RightParen) {
+		return nil, expectedRightParenthesisErr(end.Span())
+	}
+
+	return &ast.CellRef{Position: t.Pos(), Index: index, End: end.End()}, nil
+}
+
+// assignment parses NAME <- VALUE and CELL(INDEX) <- VALUE
+func (p *parser) assignment() (ast.Statement, error) {
+	var target ast.Expression
+	if p.peek().tt == Cell {
+		cell, err := p.cell()
+		if err != nil {
+			return nil, err
+		}
+		target = cell
+	} else {
+		t := p.advance()
+		target = &ast.VarRef{Position: t.Pos(), Name: t.value.(string), End: t.End()}
+	}
+
+	if !p.match(LeftArrow) {
+		return nil, expectedAssignmentOperatorErr(p.peek().Span())
+	}
+
+	value, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ast.Assign{Target: target, Value: value}, nil
+}
+
+func (p *parser) block(terminators ...tokenType) ([]ast.Statement, error) {
+	var statements []ast.Statement
+	for {
+		t := p.peek()
+		for _, tt := range terminators {
+			if t.tt == tt {
+				return statements, nil
 			}
-			if !isAtEnd() {
-				next()
+		}
+
+		if t.tt == Eof {
+			return nil, unexpectedEndOfFileErr(t.Span())
+		}
+
+		s, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, s)
+	}
+}
+
+func (p *parser) ifStatement() (ast.Statement, error) {
+	n, err := p.ifBranch(p.advance())
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.match(EndIf) {
+		return nil, expectedEndIfErr(p.peek().Span())
+	}
+
+	// Every IF of an ELSE IF chain ends at the END IF they share
+	end := p.previous().End()
+	for branch := n; branch != nil; branch = elseIf(branch) {
+		branch.End = end
+	}
+	return n, nil
+}
+
+// elseIf returns the IF nested in the ELSE branch of an ELSE IF chain. An
+// IF statement written inside a plain ELSE block already knows its end
+func elseIf(n *ast.If) *ast.If {
+	if len(n.Else) != 1 {
+		return nil
+	}
+	if next, ok := n.Else[0].(*ast.If); ok && next.End == (ast.Pos{}) {
+		return next
+	}
+	return nil
+}
+
+// ifBranch parses an IF up to its END IF, which is shared by every IF of an
+// ELSE IF chain
+func (p *parser) ifBranch(t Token) (*ast.If, error) {
+	condition, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.match(Then) {
+		return nil, expectedThenErr(p.peek().Span())
+	}
+
+	then, err := p.block(Else, EndIf)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &ast.If{Position: t.Pos(), Condition: condition, Then: then, ThenEnd: p.peek().Pos()}
+	if !p.match(Else) {
+		return n, nil
+	}
+
+	if p.peek().tt == If {
+		elseIf, err := p.ifBranch(p.advance())
+		if err != nil {
+			return nil, err
+		}
+		n.Else = []ast.Statement{elseIf}
+		return n, nil
+	}
+
+	if n.Else, err = p.block(EndIf); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (p *parser) loopStatement() (ast.Statement, error) {
+	t := p.advance()
+	count, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.match(Times) {
+		return nil, expectedTimesErr(p.peek().Span())
+	}
+
+	body, err := p.block(EndLoop)
+	if err != nil {
+		return nil, err
+	}
+
+	end := p.advance()
+	return &ast.Loop{Position: t.Pos(), Count: count, Body: body, EndLoop: end.Pos(), End: end.End()}, nil
+}
+
+func (p *parser) parameters() ([]string, error) {
+	if !p.match(LeftSquareBracket) {
+		return nil, expectedParametersErr(p.peek().Span())
+	}
+
+	var params []string
+	for !p.match(RightSquareBracket) {
+		if len(params) > 0 && !p.match(Comma) {
+			return nil, expectedParametersErr(p.peek().Span())
+		}
+
+		t := p.advance()
+		if t.tt != Identifier {
+			return nil, expectedParametersErr(t.Span())
+		}
+
+		name := t.value.(string)
+		if name == outputName {
+			return nil, reservedNameErr(t.Span(), t.lexeme)
+		}
+
+		for _, param := range params {
+			if param == name {
+				return nil, duplicatedParameterErr(t.Span(), name)
 			}
-			res = append(res, identifier(letter, lexeme, line, i))
-			break
-		case "+":
-			res = append(res, token(Plus, letter, line, i))
-			break
-		case "*":
-			res = append(res, token(Star, letter, line, i))
-			break
-		case "=":
-			res = append(res, token(Equal, letter, line, i))
+		}
+		params = append(params, name)
+	}
+
+	return params, nil
+}
+
+func (p *parser) procedureDeclaration() (ast.Statement, error) {
+	t := p.advance()
+	name := p.advance()
+	if name.tt != QuotedName {
+		return nil, expectedProcedureNameErr(name.Span())
+	}
+
+	params, err := p.parameters()
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := p.block(EndProcedure)
+	if err != nil {
+		return nil, err
+	}
+
+	end := p.advance()
+	return &ast.ProcedureDecl{
+		Position:     t.Pos(),
+		Name:         name.value.(string),
+		NameSpan:     name.Span(),
+		Params:       params,
+		Body:         body,
+		EndProcedure: end.Pos(),
+		End:          end.End(),
+	}, nil
+}
+
+func (p *parser) statement() (ast.Statement, error) {
+	switch p.peek().tt {
+	case If:
+		return p.ifStatement()
+	case Loop:
+		return p.loopStatement()
+	case DefineProcedure:
+		return p.procedureDeclaration()
+	case QuitProcedure:
+		t := p.advance()
+		return &ast.Quit{Position: t.Pos(), End: t.End()}, nil
+	case AbortLoop:
+		t := p.advance()
+		return &ast.Abort{Position: t.Pos(), End: t.End()}, nil
+	case Identifier, Cell:
+		return p.assignment()
+	}
+
+	return nil, unexpectedTokenErr(p.peek())
+}
+
+// synchronize skips the rest of the procedure that failed to parse, so every
+// procedure reports its own errors
+func (p *parser) synchronize() {
+	for {
+		t := p.peek()
+		if t.tt == Eof || t.tt == DefineProcedure {
 			break
-		case "<":
-			if !isAtEnd() && current() == "=" {
-				res = append(res, token(LesserEqual, letter+next(), line, i))
-			} else if current() == "-" {
-				res = append(res, token(LeftArrow, letter+next(), line, i))
-			} else {
-				res = append(res, token(Lesser, letter, line, i))
-			}
+		}
+
+		p.advance()
+		if t.tt == EndProcedure {
 			break
-		case ">":
-			if current() == "=" {
-				res = append(res, token(GreaterEqual, letter+next(), line, i))
-			} else {
-				res = append(res, token(Greater, letter, line, i))
+		}
+	}
+}
+
+func (p *parser) program() (*ast.Program, []error) {
+	program := &ast.Program{}
+	var errs []error
+	for !p.isAtEnd() {
+		s, err := p.statement()
+		if err != nil {
+			errs = append(errs, err)
+			p.synchronize()
+			continue
+		}
+		program.Statements = append(program.Statements, s)
+	}
+
+	program.Eof = p.peek().Pos()
+	return program, errs
+}
+
+// bareExpression parses tokens holding nothing but an expression
+func (p *parser) bareExpression() (ast.Expression, error) {
+	e, err := p.expression()
+	if err == nil && !p.isAtEnd() {
+		err = unexpectedTokenErr(p.peek())
+	}
+	return e, err
+}
+
+// isExpression reports whether the tokens left are an expression rather than
+// a statement
+func (p *parser) isExpression() bool {
+	switch p.peek().tt {
+	case If, Loop, DefineProcedure, QuitProcedure, AbortLoop, Eof:
+		return false
+	case Identifier:
+		return p.counter+1 >= len(p.tokens) || p.tokens[p.counter+1].tt != LeftArrow
+	case Cell:
+		// CELL(i) <- v is an assignment, anything else using CELL(i) isn't
+		depth := 0
+		for i := p.counter + 1; i < len(p.tokens); i++ {
+			switch p.tokens[i].tt {
+			case LeftParen:
+				depth++
+			case RightParen:
+				depth--
 			}
-			break
-		case "(":
-			res = append(res, token(LeftParen, letter, line, i))
-			break
-		case ")":
-			res = append(res, token(RightParen, letter, line, i))
-			break
-		case "[":
-			res = append(res, token(LeftSquareBracket, letter, line, i))
-			break
-		case "]":
-			res = append(res, token(RightSquareBracket, letter, line, i))
-			break
-		case ",":
-			res = append(res, token(Comma, letter, line, i))
-			break
-		default:
-			if isNumber(letter) {
-				lexeme := letter
-				for !isAtEnd() && isNumber(current()) {
-					lexeme += next()
-				}
-				value, _ := strconv.ParseInt(lexeme, 10, 64)
-				res = append(res, constant(lexeme, value, line, i))
-				break
-			} else if isLetter(letter) {
-				lexeme := letter
-				for !isAtEnd() && (isLetter(current()) || isNumber(current())) {
-					lexeme += next()
-				}
-
-				if strings.ToLower(lexeme) == "end" {
-					lexeme += next()
-					for !isAtEnd() && isLetter(current()) {
-						lexeme += next()
-					}
-
-					switch strings.ToLower(lexeme) {
-					case "endif":
-					case "endloop":
-					case "endprocedure":
-						break
-					default:
-						return nil, errors.New(fmt.Sprintf("Line %v Column %v: unexpected token %s. Expected 'end procedure', 'end if' or 'end loop' statement", line, i, current()))
-					}
-				}
-
-				if strings.ToLower(lexeme) == "define" {
-					lexeme += next()
-					for !isAtEnd() && isLetter(current()) {
-						lexeme += next()
-					}
-
-					if strings.ToLower(lexeme) != "defineprocedure" {
-						return nil, errors.New(fmt.Sprintf("Line %v Column %v: unexpected token %s. Expected 'define procedure' statement", line, i, current()))
-					}
-				}
-
-				if strings.ToLower(lexeme) == "quit" {
-					lexeme += next()
-					for !isAtEnd() && isLetter(current()) {
-						lexeme += next()
-					}
-
-					if strings.ToLower(lexeme) != "quitprocedure" {
-						return nil, errors.New(fmt.Sprintf("Line %v Column %v: unexpected token %s. Expected 'quit procedure' statement", line, i, current()))
-					}
-				}
-
-				if strings.ToLower(lexeme) == "abort" {
-					lexeme += next()
-					for !isAtEnd() && (isLetter(current()) || isNumber(current())) {
-						lexeme += next()
-					}
-
-					if strings.ToLower(lexeme) != "abortloop" {
-						return nil, errors.New(fmt.Sprintf("Line %v Column %v: unexpected token %s. Expected 'abort loop' statement", line, i, current()))
-					}
-				}
-
-				t, err := reserved(lexeme, line, i)
-				if err != nil {
-					if !isAtEnd() && current() == "?" {
-						lexeme += next()
-					}
-					t = identifier(lexeme, lexeme, line, i)
-				}
-				res = append(res, t)
-			} else {
-				return nil, errors.New(fmt.Sprintf("Line %v Column %v: unexpected token %s", line, i, current()))
+
+			if depth == 0 {
+				return i+1 >= len(p.tokens) || p.tokens[i+1].tt != LeftArrow
 			}
 		}
+		return true
+	default:
+		return true
 	}
-
-	res = append(res, Token{tt: Eof})
-	return res, nil
 }
@@ -1,155 +1,186 @@
 package compiler
 
 import (
+	"github.com/gonzispina/gloop/ast"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
 	"testing"
 )
 
-func TestLexer(t *testing.T) {
-	t.Run("It returns the correct tokens for number procedure", func(t *testing.T) {
-		text := `
-			DEFINE PROCEDURE "MINUS" [M, N]
-				IF M < N THEN
-					QUIT PROCEDURE
-				END IF
-				LOOP M + 1 TIMES
-					IF OUTPUT + N = M THEN
-						ABORT LOOP
-					END IF
-					OUTPUT <- OUTPUT + 1
-				END LOOP
+func parse(t *testing.T, text string) *ast.Program {
+	tokens, errs := Lexer(text)
+	require.Nil(t, errs)
+
+	program, errs := Parse(tokens)
+	require.Nil(t, errs)
+	return program
+}
+
+func TestParse(t *testing.T) {
+	t.Run("It builds the tree of a procedure", func(t *testing.T) {
+		program := parse(t, `DEFINE PROCEDURE "MINUS" [M, N]
+	IF M < N THEN
+		QUIT PROCEDURE
+	END IF
+	LOOP M + 1 TIMES
+		IF OUTPUT + N = M THEN
+			ABORT LOOP
+		END IF
+		OUTPUT <- OUTPUT + 1
+	END LOOP
+END PROCEDURE`)
+
+		require.Equal(t, 1, len(program.Statements))
+		decl, ok := program.Statements[0].(*ast.ProcedureDecl)
+		require.True(t, ok)
+		assert.Equal(t, "MINUS", decl.Name)
+		assert.Equal(t, []string{"M", "N"}, decl.Params)
+		assert.Equal(t, 11, decl.EndProcedure.Line)
+		require.Equal(t, 2, len(decl.Body))
+
+		guard, ok := decl.Body[0].(*ast.If)
+		require.True(t, ok)
+		assert.Equal(t, ast.Lesser, guard.Condition.(*ast.Binary).Operator)
+		assert.IsType(t, &ast.Quit{}, guard.Then[0])
+		assert.Nil(t, guard.Else)
+
+		loop, ok := decl.Body[1].(*ast.Loop)
+		require.True(t, ok)
+		assert.Equal(t, 5, loop.Position.Line)
+		assert.Equal(t, 10, loop.EndLoop.Line)
+		require.Equal(t, 2, len(loop.Body))
+
+		assign, ok := loop.Body[1].(*ast.Assign)
+		require.True(t, ok)
+		assert.Equal(t, "OUTPUT", assign.Target.(*ast.VarRef).Name)
+		sum := assign.Value.(*ast.Binary)
+		assert.Equal(t, ast.Add, sum.Operator)
+		assert.Equal(t, int64(1), sum.Right.(*ast.Literal).Value)
+	})
+
+	t.Run("ELSE IF chains are nested in the ELSE branch", func(t *testing.T) {
+		program := parse(t, `
+			IF N < 2 THEN
+				N <- 1
+			ELSE IF N < 3 THEN
+				N <- 2
+			ELSE
+				N <- 3
+			END IF
+		`)
+
+		first := program.Statements[0].(*ast.If)
+		require.Equal(t, 1, len(first.Else))
+		second := first.Else[0].(*ast.If)
+		assert.Equal(t, 1, len(second.Then))
+		assert.Equal(t, 1, len(second.Else))
+	})
+
+	t.Run("Operators keep their precedence and associativity", func(t *testing.T) {
+		program := parse(t, `X <- A + B * C + D = E AND NOT F`)
+
+		and := program.Statements[0].(*ast.Assign).Value.(*ast.Binary)
+		assert.Equal(t, ast.And, and.Operator)
+		assert.Equal(t, ast.Not, and.Right.(*ast.Unary).Operator)
+
+		equal := and.Left.(*ast.Binary)
+		assert.Equal(t, ast.Equal, equal.Operator)
+
+		outer := equal.Left.(*ast.Binary)
+		assert.Equal(t, ast.Add, outer.Operator)
+		assert.Equal(t, "D", outer.Right.(*ast.VarRef).Name)
+
+		inner := outer.Left.(*ast.Binary)
+		assert.Equal(t, ast.Add, inner.Operator)
+		assert.Equal(t, ast.Multiply, inner.Right.(*ast.Binary).Operator)
+	})
+
+	t.Run("Calls, cells and groupings are expressions", func(t *testing.T) {
+		program := parse(t, `CELL(0) <- (DOUBLE[N, 2] + CELL(1))`)
+
+		assign := program.Statements[0].(*ast.Assign)
+		assert.IsType(t, &ast.CellRef{}, assign.Target)
+
+		sum := assign.Value.(*ast.Grouping).Expression.(*ast.Binary)
+		call := sum.Left.(*ast.Call)
+		assert.Equal(t, "DOUBLE", call.Name)
+		assert.Equal(t, 2, len(call.Args))
+		assert.IsType(t, &ast.CellRef{}, sum.Right)
+	})
+
+	t.Run("Every procedure reports its own syntax errors", func(t *testing.T) {
+		tokens, lexErrs := Lexer(`
+			DEFINE PROCEDURE "A" [M]
+				OUTPUT <- 
 			END PROCEDURE
-		`
-
-		expected := []Token{
-			{tt: DefineProcedure},
-			{tt: Identifier, value: "MINUS"},
-			{tt: LeftSquareBracket},
-			{tt: Identifier, value: "M"},
-			{tt: Comma},
-			{tt: Identifier, value: "N"},
-			{tt: RightSquareBracket},
-			{tt: If},
-			{tt: Identifier, value: "M"},
-			{tt: Lesser},
-			{tt: Identifier, value: "N"},
-			{tt: Then},
-			{tt: QuitProcedure},
-			{tt: EndIf},
-			{tt: Loop},
-			{tt: Identifier, value: "M"},
-			{tt: Plus},
-			{tt: Constant, value: int64(1)},
-			{tt: Times},
-			{tt: If},
-			{tt: Identifier},
-			{tt: Plus},
-			{tt: Identifier, value: "N"},
-			{tt: Equal},
-			{tt: Identifier, value: "M"},
-			{tt: Then},
-			{tt: AbortLoop},
-			{tt: EndIf},
-			{tt: Identifier},
-			{tt: LeftArrow},
-			{tt: Identifier},
-			{tt: Plus},
-			{tt: Constant, value: int64(1)},
-			{tt: EndLoop},
-			{tt: EndProcedure},
-			{tt: Eof},
-		}
+			DEFINE PROCEDURE "B" [N
+				OUTPUT <- N
+			END PROCEDURE
+			OUTPUT <- 1
+		`)
+		require.Nil(t, lexErrs)
 
-		res, err := Lexer(text)
-		assert.Nil(t, err)
-		for i, tkn := range res {
-			assert.Equal(t, expected[i].tt, tkn.tt)
-		}
+		program, errs := Parse(tokens)
+		assert.Equal(t, 2, len(errs))
+		assert.Equal(t, 1, len(program.Statements))
 	})
+}
 
-	t.Run("It returns the correct tokens for test procedure", func(t *testing.T) {
-		text := `
-			DEFINE PROCEDURE "ISEVEN?" [N]
-				IF N < 2 THEN
-					OUTPUT <- YES
-					QUIT PROCEDURE
-				END IF
-
-				LOOP N TIMES 
-					N <- MINUS[N, 2]
-					IF N = 1 THEN
-						OUTPUT <- NO
-						QUIT PROCEDURE
-					ELSE IF N = 0 THEN
-						OUTPUT <- YES
-						QUIT PROCEDURE
-					END IF
-				END LOOP
+func TestChecker(t *testing.T) {
+	t.Run("It annotates every expression with its type", func(t *testing.T) {
+		program := parse(t, `
+			DEFINE PROCEDURE "SMALL?" [N]
+				OUTPUT <- N < 10
 			END PROCEDURE
-		`
-
-		expected := []Token{
-			{tt: DefineProcedure},
-			{tt: Identifier, value: "ISEVEN?"},
-			{tt: LeftSquareBracket},
-			{tt: Identifier, value: "N"},
-			{tt: RightSquareBracket},
-
-			{tt: If},
-			{tt: Identifier, value: "N"},
-			{tt: Lesser},
-			{tt: Constant, value: int64(2)},
-			{tt: Then},
-			{tt: Identifier},
-			{tt: LeftArrow},
-			{tt: Constant, value: true},
-			{tt: QuitProcedure},
-			{tt: EndIf},
-
-			{tt: Loop},
-			{tt: Identifier, value: "N"},
-			{tt: Times},
-
-			{tt: Identifier, value: "N"},
-			{tt: LeftArrow},
-			{tt: Identifier, value: "MINUS"},
-			{tt: LeftSquareBracket},
-			{tt: Identifier, value: "N"},
-			{tt: Comma},
-			{tt: Constant, value: int64(2)},
-			{tt: RightSquareBracket},
-
-			{tt: If},
-			{tt: Identifier, value: "N"},
-			{tt: Equal},
-			{tt: Constant, value: int64(1)},
-			{tt: Then},
-			{tt: Identifier},
-			{tt: LeftArrow},
-			{tt: Constant, value: false},
-			{tt: QuitProcedure},
-
-			{tt: Else},
-			{tt: If},
-			{tt: Identifier, value: "N"},
-			{tt: Equal},
-			{tt: Constant, value: int64(0)},
-			{tt: Then},
-			{tt: Identifier},
-			{tt: LeftArrow},
-			{tt: Constant, value: true},
-			{tt: QuitProcedure},
-			{tt: EndIf},
-
-			{tt: EndLoop},
-			{tt: EndProcedure},
-			{tt: Eof},
-		}
+			X <- 3
+			B <- SMALL?[X * 2] AND NOT (X = 1)
+		`)
 
-		res, err := Lexer(text)
-		assert.Nil(t, err)
-		for i, tkn := range res {
-			assert.Equal(t, expected[i].tt, tkn.tt)
-		}
+		c := New(nil)
+		require.Nil(t, c.checker().program(program))
+
+		types := map[string]ast.Type{}
+		ast.InspectProgram(program, func(n ast.Node) bool {
+			switch e := n.(type) {
+			case *ast.Call:
+				types[e.Name] = e.Type()
+			case *ast.VarRef:
+				types[e.Name] = e.Type()
+			case *ast.Grouping:
+				types["()"] = e.Type()
+			case *ast.Binary:
+				types[e.Operator.String()] = e.Type()
+			}
+			return true
+		})
+
+		assert.Equal(t, map[string]ast.Type{
+			"N":      ast.Number,
+			"OUTPUT": ast.Boolean,
+			"<":      ast.Boolean,
+			"X":      ast.Number,
+			"B":      ast.Boolean,
+			"SMALL?": ast.Boolean,
+			"*":      ast.Number,
+			"AND":    ast.Boolean,
+			"()":     ast.Boolean,
+			"=":      ast.Boolean,
+		}, types)
 	})
 }
+
+func BenchmarkParse(b *testing.B) {
+	text := strings.Repeat("DEFINE PROCEDURE \"TWO-TO-THE-THREE-TO-THE\" [N]\n\tOUTPUT <- CELL(0) + N * 2 # comment\nEND PROCEDURE\n", 10000)
+	tokens, errs := Lex("", text)
+	if errs != nil {
+		b.Fatal(errs)
+	}
+
+	b.SetBytes(int64(len(text)))
+	for i := 0; i < b.N; i++ {
+		if _, errs := Parse(tokens); errs != nil {
+			b.Fatal(errs)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package compiler
 
+import "github.com/gonzispina/gloop/ast"
+
 type Precedence int
 
 const (
@@ -16,51 +18,61 @@ const (
 	precedencePrimary
 )
 
-type parseFunc func() (interface{}, error)
+type parseFunc func(p *parser) (ast.Expression, error)
+
+// infixFunc receives the operand already parsed on its left
+type infixFunc func(p *parser, left ast.Expression) (ast.Expression, error)
 
 type parseRule struct {
 	prefix     parseFunc
-	infix      parseFunc
+	infix      infixFunc
 	precedence Precedence
 }
 
-func getRule(c *Compiler, tt tokenType) parseRule {
-	var rules = map[tokenType]parseRule{
-		Plus:         {nil, c.binary, precedenceTerm},
-		Star:         {nil, c.binary, precedenceFactor},
-		Equal:        {nil, c.binary, precedenceEquality},
-		Lesser:       {nil, c.binary, precedenceComparison},
-		LesserEqual:  {nil, c.binary, precedenceComparison},
-		Greater:      {nil, c.binary, precedenceComparison},
-		GreaterEqual: {nil, c.binary, precedenceComparison},
-		Not:          {c.unary, nil, precedenceNone},
+// rules is filled in init because the parse functions look rules up
+// themselves, which would otherwise be an initialization cycle
+var rules map[tokenType]parseRule
+
+func init() {
+	rules = map[tokenType]parseRule{
+		Plus:         {nil, (*parser).binary, precedenceTerm},
+		Star:         {nil, (*parser).binary, precedenceFactor},
+		Equal:        {nil, (*parser).binary, precedenceEquality},
+		Lesser:       {nil, (*parser).binary, precedenceComparison},
+		LesserEqual:  {nil, (*parser).binary, precedenceComparison},
+		Greater:      {nil, (*parser).binary, precedenceComparison},
+		GreaterEqual: {nil, (*parser).binary, precedenceComparison},
+		Not:          {(*parser).unary, nil, precedenceNone},
 
 		LeftArrow:          {nil, nil, precedenceNone},
-		LeftParen:          {c.grouping, nil, precedenceNone},
+		LeftParen:          {(*parser).grouping, nil, precedenceNone},
 		RightParen:         {nil, nil, precedenceNone},
 		LeftSquareBracket:  {nil, nil, precedenceNone},
 		RightSquareBracket: {nil, nil, precedenceNone},
 		Comma:              {nil, nil, precedenceNone},
 
-		// FALSE         : {literal,  nil,   precedenceNone},
-		// TRUE          : {literal,  nil,   precedenceNone},
 		DefineProcedure: {nil, nil, precedenceNone},
 		If:              {nil, nil, precedenceNone},
 		Then:            {nil, nil, precedenceNone},
 		Else:            {nil, nil, precedenceNone},
-		// And:           {nil, c.and, precedenceAnd},
-		// Or:            {nil, c.or, precedenceOr},
-		Loop:          {nil, nil, precedenceNone},
-		AbortLoop:     {nil, nil, precedenceNone},
-		Times:         {nil, nil, precedenceNone},
-		EndProcedure:  {nil, nil, precedenceNone},
-		QuitProcedure: {nil, nil, precedenceNone},
+		And:             {nil, (*parser).binary, precedenceAnd},
+		Or:              {nil, (*parser).binary, precedenceOr},
+		Loop:            {nil, nil, precedenceNone},
+		AbortLoop:       {nil, nil, precedenceNone},
+		Times:           {nil, nil, precedenceNone},
+		EndProcedure:    {nil, nil, precedenceNone},
+		QuitProcedure:   {nil, nil, precedenceNone},
 
-		Identifier: {c.varEvaluation, nil, precedenceNone},
-		Constant:   {c.constant, nil, precedenceNone},
+		// YES and NO are lexed as constants
+		Identifier: {(*parser).variable, nil, precedenceNone},
+		QuotedName: {(*parser).variable, nil, precedenceNone},
+		Constant:   {(*parser).literal, nil, precedenceNone},
+		Cell:       {(*parser).cell, nil, precedenceNone},
 
 		Eof: {nil, nil, precedenceNone},
 	}
+}
 
+func getRule(tt tokenType) parseRule {
 	return rules[tt]
 }
@@ -0,0 +1,53 @@
+package compiler
+
+import (
+	"github.com/gonzispina/gloop/ast"
+	"github.com/gonzispina/gloop/vm"
+	"strings"
+)
+
+// outputName is the name of the implicit variable every procedure returns
+const outputName = "OUTPUT"
+
+// reference is a call to a procedure that may not be defined yet
+type reference struct {
+	span ast.Span
+	args int
+}
+
+type procedure struct {
+	name    string
+	params  []string
+	output  ast.Type
+	fn      *vm.Function
+	index   int
+	defined bool
+	// declaration is the name of the procedure in its definition
+	declaration ast.Span
+	references  []reference
+	callees     []*procedure
+}
+
+// procedureOutputType follows GEB's convention: procedures whose names end
+// with a question mark are tests and output YES or NO, the rest output numbers
+func procedureOutputType(name string) ast.Type {
+	if strings.HasSuffix(name, "?") {
+		return booleanType
+	}
+	return numberType
+}
+
+// calls reports whether p ends up calling target
+func (p *procedure) calls(target *procedure, visited map[*procedure]bool) bool {
+	if visited[p] {
+		return false
+	}
+	visited[p] = true
+
+	for _, callee := range p.callees {
+		if callee == target || callee.calls(target, visited) {
+			return true
+		}
+	}
+	return false
+}
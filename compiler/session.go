@@ -0,0 +1,155 @@
+package compiler
+
+import "github.com/gonzispina/gloop/vm"
+
+// snapshot keeps what a failed Continue has to undo
+type snapshot struct {
+	script     vm.Chunk
+	vars       map[string]variable
+	slots      map[string]int
+	references map[string]int
+	callees    map[string]int
+	defined    map[string]bool
+}
+
+func (c *Compiler) snapshot() snapshot {
+	s := snapshot{
+		script:     c.script,
+		vars:       map[string]variable{},
+		slots:      c.slots,
+		references: map[string]int{},
+		callees:    map[string]int{},
+		defined:    map[string]bool{},
+	}
+
+	for name, v := range c.vars {
+		s.vars[name] = *v
+	}
+
+	for name, p := range c.procedures {
+		s.references[name] = len(p.references)
+		s.callees[name] = len(p.callees)
+		s.defined[name] = p.defined
+	}
+
+	return s
+}
+
+func (c *Compiler) restore(s snapshot) {
+	c.script = s.script
+	c.slots = s.slots
+
+	c.vars = map[string]*variable{}
+	for name, v := range s.vars {
+		v := v
+		c.vars[name] = &v
+	}
+
+	for name, p := range c.procedures {
+		references, ok := s.references[name]
+		if !ok {
+			delete(c.procedures, name)
+			continue
+		}
+		p.references = p.references[:references]
+		p.callees = p.callees[:s.callees[name]]
+		p.defined = s.defined[name]
+	}
+}
+
+// Continue compiles more tokens on top of everything compiled before, keeping
+// variables and procedures alive between calls. Nothing is kept when the
+// tokens don't compile. When the tokens are a bare expression the chunk
+// returns its value and the second result is true
+func (c *Compiler) Continue(tokens []Token) (vm.Chunk, bool, []error) {
+	s := c.snapshot()
+	c.tokens = tokens
+
+	chunk, expression, errs := c.compileContinuation(s.script)
+	if len(errs) != 0 {
+		c.restore(s)
+		return vm.Chunk{}, expression, errs
+	}
+
+	c.script = chunk
+	return chunk, expression, nil
+}
+
+func (c *Compiler) compileContinuation(previous vm.Chunk) (vm.Chunk, bool, []error) {
+	p := newParser(c.tokens)
+	if !p.isExpression() {
+		program, errs := p.program()
+		if len(errs) != 0 {
+			return vm.Chunk{}, false, errs
+		}
+
+		if errs := c.checker().program(program); len(errs) != 0 {
+			return vm.Chunk{}, false, errs
+		}
+
+		chunk, errs := c.generate(previous.Extend(), func(g *generator) []error {
+			return g.program(program)
+		})
+		return chunk, false, errs
+	}
+
+	e, err := p.bareExpression()
+	if err != nil {
+		return vm.Chunk{}, true, []error{err}
+	}
+
+	if errs := c.checker().bareExpression(e); len(errs) != 0 {
+		return vm.Chunk{}, true, errs
+	}
+
+	chunk, errs := c.generate(previous.Extend(), func(g *generator) []error {
+		return g.bareExpression(e, p.peek().Pos())
+	})
+	return chunk, true, errs
+}
+
+// Defines reports whether a procedure with the name was defined by an earlier
+// call to Continue
+func (c *Compiler) Defines(name string) bool {
+	p, ok := c.procedures[name]
+	return ok && p.defined
+}
+
+// IsIncomplete reports whether the tokens stop in the middle of a block or an
+// expression, so a REPL knows it has to keep reading before compiling
+func IsIncomplete(tokens []Token) bool {
+	blocks, brackets := 0, 0
+	var last Token
+	for i, t := range tokens {
+		switch t.tt {
+		case If:
+			if i == 0 || tokens[i-1].tt != Else {
+				blocks++
+			}
+		case Loop, DefineProcedure:
+			blocks++
+		case EndIf, EndLoop, EndProcedure:
+			blocks--
+		case LeftParen, LeftSquareBracket:
+			brackets++
+		case RightParen, RightSquareBracket:
+			brackets--
+		}
+
+		if t.tt != Eof {
+			last = t
+		}
+	}
+
+	if blocks > 0 || brackets > 0 {
+		return true
+	}
+
+	switch last.tt {
+	case Plus, Star, Equal, Lesser, LesserEqual, Greater, GreaterEqual, Not,
+		LeftArrow, Comma, Then, Else, Times, And, Or:
+		return true
+	default:
+		return false
+	}
+}
@@ -1,7 +1,7 @@
 package compiler
 
 import (
-	"errors"
+	"github.com/gonzispina/gloop/ast"
 	"strings"
 )
 
@@ -39,69 +39,111 @@ const (
 	Times
 
 	Identifier
+	// QuotedName is a procedure name between double quotes, like "MINUS"
+	QuotedName
 	Constant
+	Cell
 
+	// Illegal stands for text the lexer couldn't make sense of
+	Illegal
 	Eof
 )
 
+// Comment is a comment of the source, delimiters included
+type Comment struct {
+	Text string
+	Span ast.Span
+}
+
 type Token struct {
-	tt     tokenType
-	lexeme string
-	value  interface{}
-	line   int
-	column int
+	tt       tokenType
+	lexeme   string
+	value    interface{}
+	pos      ast.Pos
+	end      ast.Pos
+	comments []Comment
+}
+
+// Pos returns the position of the first character of the token
+func (t Token) Pos() ast.Pos {
+	return t.pos
+}
+
+// End returns the position right after the last character of the token
+func (t Token) End() ast.Pos {
+	return t.end
+}
+
+// Comments returns the comments written between the previous token and this
+// one. Comments at the end of the file belong to the Eof token
+func (t Token) Comments() []Comment {
+	return t.comments
+}
+
+// Span returns the text covered by the token
+func (t Token) Span() ast.Span {
+	return ast.Span{Start: t.pos, End: t.end}
+}
+
+func identifier(lexeme string, value interface{}, pos, end ast.Pos) Token {
+	return Token{tt: Identifier, lexeme: lexeme, value: value, pos: pos, end: end}
 }
 
-func identifier(lexeme string, value interface{}, line, column int) Token {
-	return Token{tt: Identifier, lexeme: lexeme, value: value, line: line, column: column}
+func quotedName(lexeme string, value interface{}, pos, end ast.Pos) Token {
+	return Token{tt: QuotedName, lexeme: lexeme, value: value, pos: pos, end: end}
 }
 
-func constant(lexeme string, value interface{}, line, column int) Token {
-	return Token{tt: Constant, lexeme: lexeme, value: value, line: line, column: column}
+func constant(lexeme string, value interface{}, pos, end ast.Pos) Token {
+	return Token{tt: Constant, lexeme: lexeme, value: value, pos: pos, end: end}
 }
 
-func token(tt tokenType, lexeme string, line, column int) Token {
-	return Token{tt: tt, lexeme: lexeme, line: line, column: column}
+func token(tt tokenType, lexeme string, pos, end ast.Pos) Token {
+	return Token{tt: tt, lexeme: lexeme, pos: pos, end: end}
 }
 
-func reserved(s string, line, column int) (Token, error) {
-	switch strings.ToLower(s) {
-	case "defineprocedure":
-		return token(DefineProcedure, strings.ToUpper(s), line, column), nil
-	case "quitprocedure":
-		return token(QuitProcedure, strings.ToUpper(s), line, column), nil
-	case "endprocedure":
-		return token(EndProcedure, strings.ToUpper(s), line, column), nil
-	case "if":
-		return token(If, strings.ToUpper(s), line, column), nil
-	case "then":
-		return token(Then, strings.ToUpper(s), line, column), nil
-	case "else":
-		return token(Else, strings.ToUpper(s), line, column), nil
-	case "endif":
-		return token(EndIf, strings.ToUpper(s), line, column), nil
-	case "not":
-		return token(Not, strings.ToUpper(s), line, column), nil
-	case "and":
-		return token(And, strings.ToUpper(s), line, column), nil
-	case "or":
-		return token(Or, strings.ToUpper(s), line, column), nil
-	case "loop":
-		return token(Loop, strings.ToUpper(s), line, column), nil
-	case "abortloop":
-		return token(AbortLoop, strings.ToUpper(s), line, column), nil
-	case "endloop":
-		return token(EndLoop, strings.ToUpper(s), line, column), nil
-	case "times":
-		return token(Times, strings.ToUpper(s), line, column), nil
-	case "output":
-		return identifier(s, s, line, column), nil
-	case "yes":
-		return constant(strings.ToUpper(s), true, line, column), nil
-	case "no":
-		return constant(strings.ToUpper(s), false, line, column), nil
+// reserved returns the token of a keyword, YES, NO or OUTPUT, whatever their
+// case. It reports false for any other word
+func reserved(s string, pos, end ast.Pos) (Token, bool) {
+	// ToUpper returns s itself when it is already upper case
+	upper := strings.ToUpper(s)
+	switch upper {
+	case "DEFINEPROCEDURE":
+		return token(DefineProcedure, upper, pos, end), true
+	case "QUITPROCEDURE":
+		return token(QuitProcedure, upper, pos, end), true
+	case "ENDPROCEDURE":
+		return token(EndProcedure, upper, pos, end), true
+	case "IF":
+		return token(If, upper, pos, end), true
+	case "THEN":
+		return token(Then, upper, pos, end), true
+	case "ELSE":
+		return token(Else, upper, pos, end), true
+	case "ENDIF":
+		return token(EndIf, upper, pos, end), true
+	case "NOT":
+		return token(Not, upper, pos, end), true
+	case "AND":
+		return token(And, upper, pos, end), true
+	case "OR":
+		return token(Or, upper, pos, end), true
+	case "LOOP":
+		return token(Loop, upper, pos, end), true
+	case "ABORTLOOP":
+		return token(AbortLoop, upper, pos, end), true
+	case "ENDLOOP":
+		return token(EndLoop, upper, pos, end), true
+	case "TIMES":
+		return token(Times, upper, pos, end), true
+	case "CELL":
+		return token(Cell, upper, pos, end), true
+	case "OUTPUT":
+		return identifier(s, upper, pos, end), true
+	case "YES":
+		return constant(upper, true, pos, end), true
+	case "NO":
+		return constant(upper, false, pos, end), true
 	default:
-		// Unreachable
-		return Token{}, errors.New("not a reserved word")
+		return Token{}, false
 	}
 }
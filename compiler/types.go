@@ -0,0 +1,363 @@
+package compiler
+
+import (
+	"github.com/gonzispina/gloop/ast"
+	"sort"
+)
+
+// checker annotates every expression of the tree with its type. It resolves
+// variables and procedures along the way, so the generator can trust the
+// tree it walks
+type checker struct {
+	c         *Compiler
+	vars      map[string]*variable
+	procedure *procedure
+	loops     int
+}
+
+func (c *Compiler) checker() *checker {
+	return &checker{c: c, vars: c.vars}
+}
+
+// program checks every statement of the program. Once a statement of the
+// script fails the rest of it is skipped until the next procedure, so every
+// procedure reports its own errors
+func (k *checker) program(program *ast.Program) []error {
+	var errs []error
+	skipping := false
+	for _, s := range program.Statements {
+		_, isProcedure := s.(*ast.ProcedureDecl)
+		if isProcedure {
+			skipping = false
+		} else if skipping {
+			continue
+		}
+
+		if err := k.statement(s); err != nil {
+			errs = append(errs, err)
+			skipping = !isProcedure
+		}
+	}
+
+	return append(errs, k.resolveReferences()...)
+}
+
+// bareExpression checks an expression typed on its own in a REPL
+func (k *checker) bareExpression(e ast.Expression) []error {
+	var errs []error
+	if _, err := k.expression(e); err != nil {
+		errs = append(errs, err)
+	}
+	return append(errs, k.resolveReferences()...)
+}
+
+func (k *checker) statements(statements []ast.Statement) error {
+	for _, s := range statements {
+		if err := k.statement(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (k *checker) statement(s ast.Statement) error {
+	switch n := s.(type) {
+	case *ast.Assign:
+		return k.assign(n)
+	case *ast.If:
+		return k.ifStatement(n)
+	case *ast.Loop:
+		return k.loop(n)
+	case *ast.Abort:
+		if k.loops == 0 {
+			return abortOutsideLoopErr(n.Span())
+		}
+	case *ast.Quit:
+		if k.procedure == nil {
+			return quitOutsideProcedureErr(n.Span())
+		}
+	case *ast.ProcedureDecl:
+		return k.procedureDeclaration(n)
+	}
+	return nil
+}
+
+func (k *checker) assign(n *ast.Assign) error {
+	if cell, ok := n.Target.(*ast.CellRef); ok {
+		if _, err := k.cell(cell); err != nil {
+			return err
+		}
+
+		t, err := k.expression(n.Value)
+		if err != nil {
+			return err
+		}
+
+		if t != numberType {
+			return invalidTypeErr(n.Value.Span(), numberType, t)
+		}
+		return nil
+	}
+
+	// The variable is declared before checking the value, so N <- N + 1
+	// reads an uninitialized variable
+	target := n.Target.(*ast.VarRef)
+	v, ok := k.vars[target.Name]
+	if !ok {
+		v = &variable{name: target.Name}
+		k.vars[target.Name] = v
+	}
+
+	t, err := k.expression(n.Value)
+	if err != nil {
+		return err
+	}
+
+	if v.initialized && v.vt != t {
+		return invalidTypeErr(n.Value.Span(), v.vt, t)
+	}
+
+	v.initialized = true
+	v.vt = t
+	target.SetType(t)
+	return nil
+}
+
+func (k *checker) ifStatement(n *ast.If) error {
+	t, err := k.expression(n.Condition)
+	if err != nil {
+		return err
+	}
+
+	if t != booleanType {
+		return booleanExpressionNeededErr(n.Condition.Span())
+	}
+
+	if err := k.statements(n.Then); err != nil {
+		return err
+	}
+	return k.statements(n.Else)
+}
+
+func (k *checker) loop(n *ast.Loop) error {
+	t, err := k.expression(n.Count)
+	if err != nil {
+		return err
+	}
+
+	if t != numberType {
+		return numberExpressionNeededErr(n.Count.Span())
+	}
+
+	k.loops++
+	defer func() { k.loops-- }()
+	return k.statements(n.Body)
+}
+
+// getProcedure returns the procedure with the given name, reserving an index
+// in the procedure table for it when it hasn't been seen yet
+func (k *checker) getProcedure(name string) *procedure {
+	p, ok := k.c.procedures[name]
+	if !ok {
+		p = &procedure{
+			name:   name,
+			output: procedureOutputType(name),
+			index:  len(k.c.procedures),
+		}
+		k.c.procedures[name] = p
+	}
+	return p
+}
+
+func (k *checker) procedureDeclaration(n *ast.ProcedureDecl) error {
+	if k.procedure != nil || k.loops > 0 {
+		return nestedProcedureErr(n.Span())
+	}
+
+	p := k.getProcedure(n.Name)
+	if p.defined {
+		return duplicatedProcedureErr(n.NameSpan, n.Name, p.declaration)
+	}
+
+	p.defined = true
+	p.declaration = n.NameSpan
+	p.params = n.Params
+
+	vars := k.vars
+	k.vars, k.procedure = map[string]*variable{}, p
+	defer func() {
+		k.vars, k.procedure = vars, nil
+	}()
+
+	for _, param := range n.Params {
+		k.vars[param] = &variable{name: param, vt: numberType, initialized: true}
+	}
+	k.vars[outputName] = &variable{name: outputName, vt: p.output, initialized: true}
+
+	return k.statements(n.Body)
+}
+
+func (k *checker) expression(e ast.Expression) (ast.Type, error) {
+	var t ast.Type
+	var err error
+	switch n := e.(type) {
+	case *ast.Literal:
+		t = numberType
+		if _, ok := n.Value.(bool); ok {
+			t = booleanType
+		}
+	case *ast.Grouping:
+		t, err = k.expression(n.Expression)
+	case *ast.VarRef:
+		t, err = k.variable(n)
+	case *ast.CellRef:
+		t, err = k.cell(n)
+	case *ast.Call:
+		t, err = k.call(n)
+	case *ast.Unary:
+		t, err = k.unary(n)
+	case *ast.Binary:
+		t, err = k.binary(n)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	e.SetType(t)
+	return t, nil
+}
+
+func (k *checker) variable(n *ast.VarRef) (ast.Type, error) {
+	v, ok := k.vars[n.Name]
+	if !ok {
+		return 0, undefinedVariableErr(n.Span(), n.Name)
+	}
+
+	if !v.initialized {
+		return 0, uninitializedVariableErr(n.Span(), n.Name)
+	}
+
+	return v.vt, nil
+}
+
+func (k *checker) cell(n *ast.CellRef) (ast.Type, error) {
+	t, err := k.expression(n.Index)
+	if err != nil {
+		return 0, err
+	}
+
+	if t != numberType {
+		return 0, numberExpressionNeededErr(n.Index.Span())
+	}
+
+	n.SetType(numberType)
+	return numberType, nil
+}
+
+func (k *checker) call(n *ast.Call) (ast.Type, error) {
+	for _, arg := range n.Args {
+		t, err := k.expression(arg)
+		if err != nil {
+			return 0, err
+		}
+
+		if t != numberType {
+			return 0, numberExpressionNeededErr(arg.Span())
+		}
+	}
+
+	p := k.getProcedure(n.Name)
+	if p.defined && len(p.params) != len(n.Args) {
+		return 0, wrongArgumentCountErr(n.Span(), p.name, len(p.params), len(n.Args), p.declaration)
+	}
+
+	p.references = append(p.references, reference{span: n.Span(), args: len(n.Args)})
+	if k.procedure != nil {
+		k.procedure.callees = append(k.procedure.callees, p)
+	}
+
+	return p.output, nil
+}
+
+func (k *checker) unary(n *ast.Unary) (ast.Type, error) {
+	t, err := k.expression(n.Operand)
+	if err != nil {
+		return 0, err
+	}
+
+	if t != booleanType {
+		return 0, booleanExpressionNeededErr(n.Operand.Span())
+	}
+
+	return booleanType, nil
+}
+
+func (k *checker) binary(n *ast.Binary) (ast.Type, error) {
+	left, err := k.expression(n.Left)
+	if err != nil {
+		return 0, err
+	}
+
+	right, err := k.expression(n.Right)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case n.Operator.Logical():
+		if left != booleanType {
+			return 0, booleanExpressionNeededErr(n.Left.Span())
+		} else if right != booleanType {
+			return 0, booleanExpressionNeededErr(n.Right.Span())
+		}
+	case n.Operator == ast.Equal:
+		if left != right {
+			return 0, mismatchedTypesErr(n.Span(), n.Left, n.Right)
+		}
+	default:
+		if left != numberType {
+			return 0, numberExpressionNeededErr(n.Left.Span())
+		} else if right != numberType {
+			return 0, numberExpressionNeededErr(n.Right.Span())
+		}
+	}
+
+	if n.Operator.Arithmetic() {
+		return numberType, nil
+	}
+	return booleanType, nil
+}
+
+// procedureTable returns the known procedures sorted by their index
+func (c *Compiler) procedureTable() []*procedure {
+	table := make([]*procedure, 0, len(c.procedures))
+	for _, p := range c.procedures {
+		table = append(table, p)
+	}
+
+	sort.Slice(table, func(i, j int) bool {
+		return table[i].index < table[j].index
+	})
+	return table
+}
+
+// resolveReferences checks the calls made to procedures that were defined
+// after being called
+func (k *checker) resolveReferences() []error {
+	var errs []error
+	for _, p := range k.c.procedureTable() {
+		for _, r := range p.references {
+			if !p.defined {
+				errs = append(errs, undefinedProcedureErr(r.span, p.name))
+			} else if len(p.params) != r.args {
+				errs = append(errs, wrongArgumentCountErr(r.span, p.name, len(p.params), r.args, p.declaration))
+			}
+		}
+
+		if p.defined && p.calls(p, map[*procedure]bool{}) {
+			errs = append(errs, recursiveProcedureErr(p.references[0].span, p.name))
+		}
+	}
+	return errs
+}
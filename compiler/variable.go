@@ -1,35 +1,25 @@
 package compiler
 
-type varType uint8
+import (
+	"github.com/gonzispina/gloop/ast"
+	"github.com/gonzispina/gloop/vm"
+)
 
 const (
-	numberType varType = iota
-	booleanType
+	numberType  = ast.Number
+	booleanType = ast.Boolean
 )
 
-func (vt varType) String() string {
-	switch vt {
-	case numberType:
-		return "number"
-	case booleanType:
-		return "boolean"
-	default:
-		// Unreachable
-		return ""
-	}
-}
-
-func constantVarType(v interface{}) varType {
-	if _, ok := v.(int); ok {
-		return numberType
-	} else {
-		return booleanType
+// kind returns the kind the VM uses to print values of the given type
+func kind(t ast.Type) vm.Kind {
+	if t == booleanType {
+		return vm.BooleanKind
 	}
+	return vm.NumberKind
 }
 
 type variable struct {
 	name        string
-	vt          varType
+	vt          ast.Type
 	initialized bool
-	slot        byte
 }
@@ -1 +1,251 @@
 package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/gonzispina/gloop/compiler"
+	"github.com/gonzispina/gloop/stdlib"
+	"github.com/gonzispina/gloop/vm"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// bytecodeExtension is the extension of the files written by 'gloop build'
+const bytecodeExtension = ".bloopc"
+
+const usage = `Usage: gloop <command> [arguments]
+
+Commands:
+  run [-p PROCEDURE] [-strict] FILE [ARGS...]
+                                     run a program and print its OUTPUT. With -p
+                                     the procedure is called with ARGS instead
+  build [-o OUT] [-strict] FILE      compile a program into a bytecode file
+  disasm FILE                        print the bytecode of a program
+  repl                               evaluate statements and expressions
+                                     interactively
+
+FILE can be BlooP source or a bytecode file written by 'gloop build'. Programs
+can call the procedures of the standard library, like MINUS or PRIME?, without
+defining them. With -strict, keywords of two words like END IF must be written
+in upper case with a single space between them, like in GEB.
+`
+
+func main() {
+	os.Exit(cli(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func cli(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	switch args[0] {
+	case "run":
+		return runCommand(args[1:], stdout, stderr)
+	case "build":
+		return buildCommand(args[1:], stdout, stderr)
+	case "disasm":
+		return disasmCommand(args[1:], stdout, stderr)
+	case "repl":
+		return replCommand(args[1:], stdin, stdout, stderr)
+	case "help", "-h", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "gloop: unknown command '%s'\n\n%s", args[0], usage)
+		return 2
+	}
+}
+
+// load compiles a source file, along with the procedures of the standard
+// library it calls or that are named, or decodes a bytecode file. The source
+// is returned too, so errors can point at it. Strict sources are lexed in
+// strict mode
+func load(path string, strict bool, procedures ...string) (vm.Chunk, string, []error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return vm.Chunk{}, "", []error{err}
+	}
+	defer f.Close()
+
+	if filepath.Ext(path) == bytecodeExtension {
+		chunk, err := vm.ReadChunk(f)
+		if err != nil {
+			return vm.Chunk{}, "", []error{err}
+		}
+		return chunk, "", nil
+	}
+
+	text, err := io.ReadAll(f)
+	if err != nil {
+		return vm.Chunk{}, "", []error{err}
+	}
+
+	lex := compiler.Lex
+	if strict {
+		lex = compiler.LexStrict
+	}
+
+	tokens, errs := lex(path, string(text))
+	if len(errs) > 0 {
+		return vm.Chunk{}, string(text), errs
+	}
+
+	chunk, errs := stdlib.Compile(tokens, procedures...)
+	if len(errs) == 0 {
+		chunk.SetSource(text)
+	}
+	return chunk, string(text), errs
+}
+
+// printErrors prints every error after the path it comes from. Diagnostics
+// are rendered along with the lines of the source they point at, which may be
+// in the standard library
+func printErrors(stderr io.Writer, path string, source string, errs []error) {
+	for _, err := range errs {
+		var d *compiler.Diagnostic
+		if errors.As(err, &d) && d.Span.Start.File != "" {
+			file := d.Span.Start.File
+			if file == path {
+				d.Render(stderr, source)
+				continue
+			}
+
+			if library, ok := stdlib.Source(file); ok {
+				d.Render(stderr, library)
+				continue
+			}
+		}
+		fmt.Fprintf(stderr, "%s: %v\n", path, err)
+	}
+}
+
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+	}
+	return flags
+}
+
+func runCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := newFlagSet("run", stderr)
+	procedure := flags.String("p", "", "procedure to call with the arguments")
+	strict := flags.Bool("strict", false, "only accept keywords written like in GEB")
+	if err := flags.Parse(args); err != nil || flags.NArg() == 0 {
+		return 2
+	}
+
+	path := flags.Arg(0)
+	var values []vm.Value
+	for _, arg := range flags.Args()[1:] {
+		n, ok := new(big.Int).SetString(arg, 10)
+		if !ok || n.Sign() < 0 {
+			fmt.Fprintf(stderr, "gloop: '%s' is not a natural number\n", arg)
+			return 2
+		}
+		values = append(values, vm.BigValue(n))
+	}
+
+	if *procedure == "" && len(values) > 0 {
+		fmt.Fprintln(stderr, "gloop: arguments can only be passed to a procedure, use -p PROCEDURE")
+		return 2
+	}
+
+	// Procedure names are case insensitive, and compiled upper cased
+	*procedure = strings.ToUpper(*procedure)
+
+	var procedures []string
+	if *procedure != "" {
+		procedures = append(procedures, *procedure)
+	}
+
+	chunk, source, errs := load(path, *strict, procedures...)
+	if len(errs) > 0 {
+		printErrors(stderr, path, source, errs)
+		return 1
+	}
+
+	machine := vm.New()
+	kind := chunk.Output()
+	var res vm.Value
+	var err error
+	if *procedure == "" {
+		res, err = machine.Run(chunk)
+	} else {
+		if fn, ok := chunk.Procedure(*procedure); ok {
+			kind = fn.Chunk.Output()
+		}
+		res, err = machine.Call(chunk, *procedure, values...)
+	}
+
+	if err != nil {
+		printErrors(stderr, path, source, []error{err})
+		return 1
+	}
+
+	fmt.Fprintln(stdout, res.Format(kind))
+	return 0
+}
+
+func buildCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := newFlagSet("build", stderr)
+	out := flags.String("o", "", "output file")
+	strict := flags.Bool("strict", false, "only accept keywords written like in GEB")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return 2
+	}
+
+	path := flags.Arg(0)
+	if *out == "" {
+		*out = strings.TrimSuffix(path, filepath.Ext(path)) + bytecodeExtension
+	}
+
+	chunk, source, errs := load(path, *strict)
+	if len(errs) > 0 {
+		printErrors(stderr, path, source, errs)
+		return 1
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		printErrors(stderr, *out, "", []error{err})
+		return 1
+	}
+
+	if err := vm.WriteChunk(f, chunk); err != nil {
+		f.Close()
+		printErrors(stderr, *out, "", []error{err})
+		return 1
+	}
+
+	if err := f.Close(); err != nil {
+		printErrors(stderr, *out, "", []error{err})
+		return 1
+	}
+
+	return 0
+}
+
+func disasmCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := newFlagSet("disasm", stderr)
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return 2
+	}
+
+	path := flags.Arg(0)
+	chunk, source, errs := load(path, false)
+	if len(errs) > 0 {
+		printErrors(stderr, path, source, errs)
+		return 1
+	}
+
+	fmt.Fprint(stdout, vm.Disassemble(chunk, path))
+	return 0
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const minus = `
+DEFINE PROCEDURE "MINUS" [M, N]
+	IF M < N THEN
+		QUIT PROCEDURE
+	END IF
+	LOOP M + 1 TIMES
+		IF OUTPUT + N = M THEN
+			ABORT LOOP
+		END IF
+		OUTPUT <- OUTPUT + 1
+	END LOOP
+END PROCEDURE
+
+DEFINE PROCEDURE "SQUARE" [N]
+	OUTPUT <- N * N
+END PROCEDURE
+
+DEFINE PROCEDURE "SMALLER?" [M, N]
+	OUTPUT <- M < N
+END PROCEDURE
+
+OUTPUT <- MINUS[10, 3]
+`
+
+func writeFile(t *testing.T, name string, text string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.Nil(t, os.WriteFile(path, []byte(text), 0o644))
+	return path
+}
+
+func execute(args ...string) (int, string, string) {
+	return executeWithInput("", args...)
+}
+
+func executeWithInput(stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := cli(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCli(t *testing.T) {
+	t.Run("Run prints the OUTPUT of the program", func(t *testing.T) {
+		path := writeFile(t, "minus.bloop", minus)
+
+		code, stdout, _ := execute("run", path)
+		assert.Equal(t, 0, code)
+		assert.Equal(t, "7\n", stdout)
+	})
+
+	t.Run("Run calls a procedure with the given arguments", func(t *testing.T) {
+		path := writeFile(t, "minus.bloop", minus)
+
+		code, stdout, _ := execute("run", "-p", "MINUS", path, "10", "4")
+		assert.Equal(t, 0, code)
+		assert.Equal(t, "6\n", stdout)
+
+		code, stdout, _ = execute("run", "-p", "SQUARE", path, "100000000000000000000")
+		assert.Equal(t, 0, code)
+		assert.Equal(t, "10000000000000000000000000000000000000000\n", stdout)
+
+		code, stdout, _ = execute("run", "-p", "smaller?", path, "1", "2")
+		assert.Equal(t, 0, code)
+		assert.Equal(t, "YES\n", stdout)
+	})
+
+	t.Run("Programs can call the standard library without defining it", func(t *testing.T) {
+		path := writeFile(t, "primes.bloop", "OUTPUT <- PRIME?[MINUS[100, 3]] AND NOT DIVIDES?[3, 10]")
+
+		code, stdout, _ := execute("run", path)
+		assert.Equal(t, 0, code)
+		assert.Equal(t, "YES\n", stdout)
+
+		path = writeFile(t, "empty.bloop", "")
+		code, stdout, _ = execute("run", "-p", "factorial", path, "5")
+		assert.Equal(t, 0, code)
+		assert.Equal(t, "120\n", stdout)
+
+		code, stdout, _ = execute("run", "-p", "PRIME?", path, "7")
+		assert.Equal(t, 0, code)
+		assert.Equal(t, "YES\n", stdout)
+
+		path = writeFile(t, "minus.bloop", minus)
+		code, _, stderr := execute("run", "-p", "PRIME?", path, "7")
+		assert.Equal(t, 1, code)
+		assert.Contains(t, stderr, "error[Hidden library procedure]: procedure 'MINUS' hides the one of the standard library that 'PRIME?' calls")
+	})
+
+	t.Run("Strict mode only accepts keywords written like in GEB", func(t *testing.T) {
+		path := writeFile(t, "loose.bloop", "IF 1 < 2 THEN\n\tOUTPUT <- 3\nend if")
+
+		code, stdout, _ := execute("run", path)
+		assert.Equal(t, 0, code)
+		assert.Equal(t, "3\n", stdout)
+
+		code, _, stderr := execute("run", "-strict", path)
+		assert.Equal(t, 1, code)
+		assert.Contains(t, stderr, "error[Non standard keyword]")
+
+		code, _, stderr = execute("build", "-strict", "-o", filepath.Join(t.TempDir(), "loose.bloopc"), path)
+		assert.Equal(t, 1, code)
+		assert.Contains(t, stderr, "error[Non standard keyword]")
+
+		path = writeFile(t, "strict.bloop", "IF 1 < 2 THEN\n\tOUTPUT <- 3\nEND IF")
+		code, stdout, _ = execute("run", "-strict", path)
+		assert.Equal(t, 0, code)
+		assert.Equal(t, "3\n", stdout)
+	})
+
+	t.Run("Errors pointing into the standard library show where it is", func(t *testing.T) {
+		path := writeFile(t, "wrong.bloop", "A <- 1\nB <- 2\nOUTPUT <- MINUS[A]")
+
+		code, _, stderr := execute("run", path)
+		assert.Equal(t, 1, code)
+		assert.Contains(t, stderr, "3 | OUTPUT <- MINUS[A]\n")
+		assert.Contains(t, stderr, "::: stdlib/arithmetic.bloop:5:18: defined here\n")
+		assert.NotContains(t, stderr, "1 | A <- 1")
+	})
+
+	t.Run("Compile errors are printed with the file name and exit non zero", func(t *testing.T) {
+		path := writeFile(t, "broken.bloop", `
+			DEFINE PROCEDURE "A" [M]
+				OUTPUT <- N
+			END PROCEDURE
+			DEFINE PROCEDURE "B" [N]
+				OUTPUT <- M
+			END PROCEDURE
+		`)
+
+		code, stdout, stderr := execute("run", path)
+		assert.Equal(t, 1, code)
+		assert.Empty(t, stdout)
+		assert.Equal(t, 2, bytes.Count([]byte(stderr), []byte(path+":")))
+		assert.Contains(t, stderr, "--> "+path+":3:15\n")
+		assert.Contains(t, stderr, "--> "+path+":6:15\n")
+		assert.Contains(t, stderr, "OUTPUT <- N\n")
+		assert.Contains(t, stderr, "error[Undefined variable]: cannot evaluate 'M'")
+	})
+
+	t.Run("Every typo of a file is reported in one run", func(t *testing.T) {
+		path := writeFile(t, "typos.bloop", "N <- 1 $ 2\nEND FOO\nOUTPUT <- N @ 3\n")
+
+		code, _, stderr := execute("run", path)
+		assert.Equal(t, 1, code)
+		assert.Contains(t, stderr, "--> "+path+":1:8\n")
+		assert.Contains(t, stderr, "--> "+path+":2:5\n")
+		assert.Contains(t, stderr, "--> "+path+":3:13\n")
+	})
+
+	t.Run("Build writes a bytecode file that can be run and disassembled", func(t *testing.T) {
+		path := writeFile(t, "minus.bloop", minus)
+		out := filepath.Join(filepath.Dir(path), "minus"+bytecodeExtension)
+
+		code, _, stderr := execute("build", path)
+		require.Equal(t, 0, code, stderr)
+
+		code, stdout, _ := execute("run", out)
+		assert.Equal(t, 0, code)
+		assert.Equal(t, "7\n", stdout)
+
+		code, stdout, _ = execute("disasm", out)
+		assert.Equal(t, 0, code)
+		assert.Contains(t, stdout, "== MINUS ==")
+		assert.Contains(t, stdout, "OP_CALL")
+	})
+
+	t.Run("Unknown commands and missing files exit non zero", func(t *testing.T) {
+		code, _, _ := execute("fly")
+		assert.Equal(t, 2, code)
+
+		code, _, stderr := execute("run", filepath.Join(t.TempDir(), "missing.bloop"))
+		assert.Equal(t, 1, code)
+		assert.Contains(t, stderr, "missing.bloop")
+	})
+}
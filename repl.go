@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/gonzispina/gloop/compiler"
+	"github.com/gonzispina/gloop/stdlib"
+	"github.com/gonzispina/gloop/vm"
+	"io"
+	"strings"
+)
+
+const (
+	prompt             = "> "
+	continuationPrompt = "... "
+)
+
+// replCommand reads statements, procedure definitions and expressions one at
+// a time, keeping variables and procedures between inputs and echoing the
+// value of expressions. Inputs can call the procedures of the standard
+// library like programs given to run
+func replCommand(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := newFlagSet("repl", stderr)
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return 2
+	}
+
+	session := stdlib.NewSession(compiler.New(nil))
+	machine := vm.New()
+	scanner := bufio.NewScanner(stdin)
+
+	// Every input is lexed as a file of its own, named like <input 1>, and
+	// kept so diagnostics pointing into earlier inputs can show them
+	inputs := map[string]string{}
+
+	var input strings.Builder
+	fmt.Fprint(stdout, prompt)
+	for scanner.Scan() {
+		input.WriteString(scanner.Text())
+		input.WriteString("\n")
+
+		file := fmt.Sprintf("<input %d>", len(inputs)+1)
+		tokens, errs := compiler.Lex(file, input.String())
+		if len(errs) == 0 && compiler.IsIncomplete(tokens) || unfinished(errs, input.Len()) {
+			fmt.Fprint(stdout, continuationPrompt)
+			continue
+		}
+
+		text := input.String()
+		input.Reset()
+		if len(errs) > 0 {
+			inputs[file] = text
+			printInputErrors(stderr, inputs, errs)
+		} else if len(tokens) > 1 {
+			inputs[file] = text
+			evaluate(session, machine, inputs, tokens, stdout, stderr)
+		}
+		fmt.Fprint(stdout, prompt)
+	}
+	fmt.Fprintln(stdout)
+
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// unfinished reports whether the only error of the input may be fixed by the
+// next lines: a block comment that isn't closed yet, or a keyword like END IF
+// whose second word comes in the next line
+func unfinished(errs []error, length int) bool {
+	if len(errs) != 1 {
+		return false
+	}
+
+	var d *compiler.Diagnostic
+	if errors.Is(errs[0], compiler.ExpectedKeywordErrCode) && errors.As(errs[0], &d) {
+		return d.Span.Start.Offset == length
+	}
+	return errors.Is(errs[0], compiler.UnterminatedCommentErrCode)
+}
+
+func evaluate(session *stdlib.Session, machine *vm.VM, inputs map[string]string, tokens []compiler.Token, stdout io.Writer, stderr io.Writer) {
+	chunk, expression, errs := session.Continue(tokens)
+	if len(errs) != 0 {
+		printInputErrors(stderr, inputs, errs)
+		return
+	}
+
+	res, err := machine.Continue(chunk)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return
+	}
+
+	if expression {
+		fmt.Fprintln(stdout, res.Format(chunk.Output()))
+	}
+}
+
+// printInputErrors renders diagnostics like run does, along with the lines of
+// the input or of the standard library they point at. Labels pointing into
+// other inputs are listed with their position
+func printInputErrors(stderr io.Writer, inputs map[string]string, errs []error) {
+	for _, err := range errs {
+		var d *compiler.Diagnostic
+		if !errors.As(err, &d) {
+			fmt.Fprintln(stderr, err)
+			continue
+		}
+
+		if library, ok := stdlib.Source(d.Span.Start.File); ok {
+			d.Render(stderr, library)
+		} else {
+			d.Render(stderr, inputs[d.Span.Start.File])
+		}
+	}
+}
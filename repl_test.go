@@ -0,0 +1,140 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRepl(t *testing.T) {
+	t.Run("Variables and procedures survive between inputs", func(t *testing.T) {
+		input := `N <- 3
+DEFINE PROCEDURE "DOUBLE" [M]
+	OUTPUT <- M + M
+END PROCEDURE
+N <- DOUBLE[N]
+N + 1
+N < 2
+`
+
+		code, stdout, stderr := executeWithInput(input, "repl")
+		assert.Equal(t, 0, code)
+		assert.Empty(t, stderr)
+		assert.Equal(t, "> > ... ... > > 7\n> NO\n> \n", stdout)
+	})
+
+	t.Run("Multi line blocks wait for their end", func(t *testing.T) {
+		input := `OUTPUT <- 0
+LOOP 3
+TIMES
+	OUTPUT <- OUTPUT + 2
+END LOOP
+OUTPUT
+`
+
+		_, stdout, _ := executeWithInput(input, "repl")
+		assert.Equal(t, "> > ... ... ... > 6\n> \n", stdout)
+	})
+
+	t.Run("Block comments wait for their end", func(t *testing.T) {
+		input := `{ a comment
+spanning lines } 1 + 2
+`
+
+		_, stdout, stderr := executeWithInput(input, "repl")
+		assert.Empty(t, stderr)
+		assert.Equal(t, "> ... 3\n> \n", stdout)
+	})
+
+	t.Run("Keywords can be split across lines", func(t *testing.T) {
+		input := `IF 1 < 2 THEN
+	OUTPUT <- 5
+END
+IF
+OUTPUT
+`
+
+		_, stdout, stderr := executeWithInput(input, "repl")
+		assert.Empty(t, stderr)
+		assert.Equal(t, "> ... ... ... > 5\n> \n", stdout)
+	})
+
+	t.Run("Inputs that don't compile are forgotten", func(t *testing.T) {
+		input := `N <- M
+N <- 1
+DEFINE PROCEDURE "BAD" [M]
+	OUTPUT <- N
+END PROCEDURE
+DEFINE PROCEDURE "BAD" [M]
+	OUTPUT <- M
+END PROCEDURE
+BAD[N]
+`
+
+		code, stdout, stderr := executeWithInput(input, "repl")
+		assert.Equal(t, 0, code)
+		assert.Contains(t, stderr, "Undefined variable")
+		assert.NotContains(t, stderr, "Duplicated procedure")
+		assert.Contains(t, stdout, "> 1\n")
+	})
+
+	t.Run("Inputs can call the standard library", func(t *testing.T) {
+		input := `OUTPUT <- MINUS[5, 2]
+OUTPUT
+PRIME?[7]
+MINUS[9, 4]
+`
+
+		code, stdout, stderr := executeWithInput(input, "repl")
+		assert.Equal(t, 0, code)
+		assert.Empty(t, stderr)
+		assert.Equal(t, "> > 3\n> YES\n> 5\n> \n", stdout)
+	})
+
+	t.Run("Procedures of earlier inputs can't hide the ones the library calls", func(t *testing.T) {
+		input := `DEFINE PROCEDURE "MINUS" [M, N]
+	OUTPUT <- 0
+END PROCEDURE
+MINUS[3, 1]
+PRIME?[7]
+`
+
+		code, stdout, stderr := executeWithInput(input, "repl")
+		assert.Equal(t, 0, code)
+		assert.Contains(t, stderr, "Hidden library procedure")
+		assert.Contains(t, stdout, "> 0\n")
+	})
+
+	t.Run("Errors point at the input", func(t *testing.T) {
+		input := `IF 1 < 2 THEN
+	OUTPUT <- 1 + YES
+END IF
+`
+
+		_, _, stderr := executeWithInput(input, "repl")
+		assert.Equal(t, `error[Number expression needed]: needed number expression
+ --> <input 1>:2:16
+  |
+2 | 	OUTPUT <- 1 + YES
+  | 	              ^~~
+`, stderr)
+	})
+
+	t.Run("Labels pointing into earlier inputs are listed with their input", func(t *testing.T) {
+		input := `N <- 1
+DEFINE PROCEDURE "P" [M]
+END PROCEDURE
+DEFINE PROCEDURE "P" [M]
+	OUTPUT <- M
+END PROCEDURE
+`
+
+		_, _, stderr := executeWithInput(input, "repl")
+		assert.Equal(t, `error[Duplicated procedure]: procedure 'P' is already defined
+ --> <input 3>:1:18
+  |
+1 | DEFINE PROCEDURE "P" [M]
+  |                  ^~~
+  ::: <input 2>:1:18: first defined here
+`, stderr)
+	})
+}
@@ -0,0 +1,79 @@
+package stdlib
+
+import (
+	"github.com/gonzispina/gloop/ast"
+	"github.com/gonzispina/gloop/compiler"
+	"github.com/gonzispina/gloop/vm"
+)
+
+// Session links the library into the inputs of a REPL. The procedures an
+// input needs are linked the first time, and kept by the compiler for the
+// inputs that follow
+type Session struct {
+	compiler *compiler.Compiler
+	linked   map[string]bool
+}
+
+func NewSession(c *compiler.Compiler) *Session {
+	return &Session{compiler: c, linked: map[string]bool{}}
+}
+
+// Continue links the library procedures the tokens need and compiles them
+// like compiler.Continue. Procedures defined by earlier inputs are used
+// instead of the ones of the library, as long as no linked procedure calls
+// them
+func (s *Session) Continue(tokens []compiler.Token) (vm.Chunk, bool, []error) {
+	procedures, errs := load()
+	if len(errs) != 0 {
+		return vm.Chunk{}, false, errs
+	}
+
+	program, expression, errs := compiler.ParseInput(tokens)
+	if len(errs) != 0 {
+		return vm.Chunk{}, expression != nil, errs
+	}
+
+	l := newLinker(procedures)
+	l.earlier = func(name string) bool {
+		return s.compiler.Defines(name) && !s.linked[name]
+	}
+	for name := range s.linked {
+		l.linked[name] = true
+	}
+
+	if program != nil {
+		l.program(program)
+	} else {
+		ast.Inspect(expression, l.inspect)
+	}
+
+	if len(l.errs) != 0 {
+		return vm.Chunk{}, expression != nil, l.errs
+	}
+
+	if len(l.tokens) == 0 {
+		return s.compiler.Continue(tokens)
+	}
+
+	// A bare expression can't follow the procedures in the same input, so
+	// they are compiled first
+	if expression != nil {
+		if _, _, errs := s.compiler.Continue(l.tokens); len(errs) != 0 {
+			return vm.Chunk{}, true, errs
+		}
+		s.link(l.names)
+		return s.compiler.Continue(tokens)
+	}
+
+	chunk, _, errs := s.compiler.Continue(append(l.tokens, tokens...))
+	if len(errs) == 0 {
+		s.link(l.names)
+	}
+	return chunk, false, errs
+}
+
+func (s *Session) link(names []string) {
+	for _, name := range names {
+		s.linked[name] = true
+	}
+}
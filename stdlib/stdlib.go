@@ -0,0 +1,257 @@
+// Package stdlib is the standard library of BlooP: the procedures of chapter
+// XIII of Gödel, Escher, Bach written in BlooP itself. The sources are
+// embedded in the binary and compiled the first time they are needed
+package stdlib
+
+import (
+	"embed"
+	"fmt"
+	"github.com/gonzispina/gloop/ast"
+	"github.com/gonzispina/gloop/compiler"
+	"github.com/gonzispina/gloop/vm"
+	"io/fs"
+	"path"
+	"sort"
+	"sync"
+)
+
+//go:embed *.bloop
+var sources embed.FS
+
+// procedure is a procedure of the library along with the procedures it calls
+type procedure struct {
+	tokens  []compiler.Token
+	callees []string
+}
+
+var (
+	once       sync.Once
+	procedures map[string]*procedure
+	loadErrs   []error
+)
+
+// load parses every source of the library once
+func load() (map[string]*procedure, []error) {
+	once.Do(func() {
+		procedures = map[string]*procedure{}
+		files, _ := fs.Glob(sources, "*.bloop")
+		for _, file := range files {
+			text, _ := sources.ReadFile(file)
+			loadErrs = append(loadErrs, parse(path.Join("stdlib", file), string(text))...)
+		}
+	})
+	return procedures, loadErrs
+}
+
+// parse adds the procedures of a source file to the library
+func parse(file string, text string) []error {
+	tokens, errs := compiler.Lex(file, text)
+	if len(errs) != 0 {
+		return errs
+	}
+
+	program, errs := compiler.Parse(tokens)
+	if len(errs) != 0 {
+		return errs
+	}
+
+	for _, s := range program.Statements {
+		decl, ok := s.(*ast.ProcedureDecl)
+		if !ok {
+			continue
+		}
+
+		p := &procedure{}
+		for _, t := range tokens {
+			if t.Pos().Offset >= decl.Position.Offset && t.End().Offset <= decl.End.Offset {
+				p.tokens = append(p.tokens, t)
+			}
+		}
+
+		ast.Inspect(decl, func(n ast.Node) bool {
+			if call, ok := n.(*ast.Call); ok {
+				p.callees = append(p.callees, call.Name)
+			}
+			return true
+		})
+		procedures[decl.Name] = p
+	}
+	return nil
+}
+
+// Source returns the text of a file of the library, named like the spans of
+// its diagnostics, like stdlib/primes.bloop
+func Source(file string) (string, bool) {
+	dir, name := path.Split(file)
+	if dir != "stdlib/" {
+		return "", false
+	}
+
+	text, err := sources.ReadFile(name)
+	if err != nil {
+		return "", false
+	}
+	return string(text), true
+}
+
+// Names returns the names of the procedures of the library, sorted
+func Names() []string {
+	procedures, _ := load()
+	res := make([]string, 0, len(procedures))
+	for name := range procedures {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// HiddenProcedureErrCode is reported when a program defines a procedure
+// that a procedure of the library it uses calls
+const HiddenProcedureErrCode compiler.ErrCode = "Hidden library procedure"
+
+func hiddenProcedureErr(decl *ast.ProcedureDecl, caller string) error {
+	return &compiler.Diagnostic{
+		Severity: compiler.SeverityError,
+		Code:     HiddenProcedureErrCode,
+		Message:  fmt.Sprintf("procedure '%s' hides the one of the standard library that '%s' calls", decl.Name, caller),
+		Span:     decl.NameSpan,
+		Notes:    []string{"library procedures only call each other, rename this procedure"},
+	}
+}
+
+// hiddenEarlierErr is hiddenProcedureErr for a procedure defined by an
+// earlier input of a REPL. It points at the call that needs the library
+func hiddenEarlierErr(name string, caller string, call ast.Span) error {
+	return &compiler.Diagnostic{
+		Severity: compiler.SeverityError,
+		Code:     HiddenProcedureErrCode,
+		Message:  fmt.Sprintf("procedure '%s' defined earlier hides the one of the standard library that '%s' calls", name, caller),
+		Span:     call,
+		Notes:    []string{"library procedures only call each other, rename that procedure"},
+	}
+}
+
+// linker collects the library procedures a program needs. Procedures are
+// linked depth first, so every one comes after the ones it calls
+type linker struct {
+	procedures map[string]*procedure
+	// defined are the procedures of the program
+	defined map[string]*ast.ProcedureDecl
+	// earlier reports the procedures defined by earlier inputs of a REPL,
+	// linked reports the ones of the library among them
+	earlier func(name string) bool
+	linked  map[string]bool
+	hidden  map[string]bool
+
+	tokens []compiler.Token
+	names  []string
+	errs   []error
+}
+
+func newLinker(procedures map[string]*procedure) *linker {
+	return &linker{
+		procedures: procedures,
+		defined:    map[string]*ast.ProcedureDecl{},
+		earlier:    func(string) bool { return false },
+		linked:     map[string]bool{},
+		hidden:     map[string]bool{},
+	}
+}
+
+// inspect links the procedures called within the node
+func (l *linker) inspect(n ast.Node) bool {
+	if call, ok := n.(*ast.Call); ok {
+		l.link(call.Name, call.Span())
+	}
+	return true
+}
+
+// program links the procedures the program calls once all of its own
+// procedures are known
+func (l *linker) program(program *ast.Program) {
+	ast.InspectProgram(program, func(n ast.Node) bool {
+		if decl, ok := n.(*ast.ProcedureDecl); ok {
+			l.defined[decl.Name] = decl
+		}
+		return true
+	})
+	ast.InspectProgram(program, l.inspect)
+}
+
+// link links the procedure along with the ones it calls. The span is the
+// call that needs it
+func (l *linker) link(name string, call ast.Span) {
+	p, ok := l.procedures[name]
+	if !ok || l.linked[name] || l.defined[name] != nil || l.earlier(name) {
+		return
+	}
+
+	l.linked[name] = true
+	l.names = append(l.names, name)
+	for _, callee := range p.callees {
+		if !l.hidden[callee] {
+			if decl, ok := l.defined[callee]; ok {
+				l.hidden[callee] = true
+				l.errs = append(l.errs, hiddenProcedureErr(decl, name))
+			} else if !l.linked[callee] && l.earlier(callee) {
+				l.hidden[callee] = true
+				l.errs = append(l.errs, hiddenEarlierErr(callee, name, call))
+			}
+		}
+		l.link(callee, call)
+	}
+	l.tokens = append(l.tokens, p.tokens...)
+}
+
+// link returns the tokens of the library procedures the program needs
+func link(program *ast.Program, names []string) ([]compiler.Token, []error) {
+	procedures, errs := load()
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	l := newLinker(procedures)
+	l.program(program)
+	for _, name := range names {
+		l.link(name, ast.Span{})
+	}
+
+	if len(l.errs) != 0 {
+		return nil, l.errs
+	}
+	return l.tokens, nil
+}
+
+// Compile puts before a program the library procedures it calls, along with
+// the ones they call in turn, and compiles it. Procedures the program defines
+// itself are used instead of the ones of the library, but they can't take
+// the name of a procedure the linked ones call. The names are linked even
+// when the program doesn't call them, so a procedure can be called with
+// vm.Call. The program is parsed once, only the linked procedures are parsed
+// again
+func Compile(tokens []compiler.Token, names ...string) (vm.Chunk, []error) {
+	program, errs := compiler.Parse(tokens)
+	if len(errs) != 0 {
+		return vm.Chunk{}, errs
+	}
+
+	library, errs := link(program, names)
+	if len(errs) != 0 {
+		return vm.Chunk{}, errs
+	}
+
+	if len(library) != 0 {
+		// The trees of the library aren't shared, the checker writes types
+		// into them
+		linked, errs := compiler.Parse(library)
+		if len(errs) != 0 {
+			return vm.Chunk{}, errs
+		}
+		program = &ast.Program{
+			Statements: append(linked.Statements, program.Statements...),
+			Eof:        program.Eof,
+		}
+	}
+
+	return compiler.New(tokens).CompileProgram(program)
+}
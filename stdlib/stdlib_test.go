@@ -0,0 +1,229 @@
+package stdlib_test
+
+import (
+	"errors"
+	"github.com/gonzispina/gloop/compiler"
+	"github.com/gonzispina/gloop/stdlib"
+	"github.com/gonzispina/gloop/vm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"testing"
+)
+
+// library compiles every procedure of the library
+func library(t *testing.T) vm.Chunk {
+	tokens, errs := compiler.Lex("test.bloop", "")
+	require.Nil(t, errs)
+
+	chunk, errs := stdlib.Compile(tokens, stdlib.Names()...)
+	require.Nil(t, errs)
+	return chunk
+}
+
+func call(t *testing.T, chunk vm.Chunk, name string, args ...uint64) vm.Value {
+	values := make([]vm.Value, len(args))
+	for i, arg := range args {
+		values[i] = vm.NewValue(arg)
+	}
+
+	res, err := vm.New().Call(chunk, name, values...)
+	require.Nil(t, err, "%s%v", name, args)
+	return res
+}
+
+func answer(b bool) string {
+	if b {
+		return "YES"
+	}
+	return "NO"
+}
+
+func isPrime(n uint64) bool {
+	if n < 2 {
+		return false
+	}
+	for d := uint64(2); d*d <= n; d++ {
+		if n%d == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestLibrary(t *testing.T) {
+	chunk := library(t)
+
+	t.Run("Binary procedures match Go for the first inputs", func(t *testing.T) {
+		for m := uint64(0); m < 20; m++ {
+			for n := uint64(0); n < 20; n++ {
+				minus := uint64(0)
+				if m > n {
+					minus = m - n
+				}
+				assert.Equal(t, minus, call(t, chunk, "MINUS", m, n).Big().Uint64(), "MINUS[%d, %d]", m, n)
+				assert.Equal(t, answer(m == n), call(t, chunk, "EQUAL?", m, n).Format(vm.BooleanKind), "EQUAL?[%d, %d]", m, n)
+
+				remainder, divides := uint64(0), n == 0
+				if n != 0 {
+					remainder = m % n
+				}
+				if m != 0 {
+					divides = n%m == 0
+				}
+				assert.Equal(t, remainder, call(t, chunk, "REMAINDER", m, n).Big().Uint64(), "REMAINDER[%d, %d]", m, n)
+				assert.Equal(t, answer(divides), call(t, chunk, "DIVIDES?", m, n).Format(vm.BooleanKind), "DIVIDES?[%d, %d]", m, n)
+			}
+		}
+	})
+
+	t.Run("PRIME? matches Go for the first inputs", func(t *testing.T) {
+		for n := uint64(0); n < 300; n++ {
+			assert.Equal(t, answer(isPrime(n)), call(t, chunk, "PRIME?", n).Format(vm.BooleanKind), "PRIME?[%d]", n)
+		}
+	})
+
+	t.Run("GOLDBACH? matches Go for the first inputs", func(t *testing.T) {
+		for n := uint64(0); n < 100; n++ {
+			expected := false
+			for p := uint64(2); p <= n; p++ {
+				expected = expected || isPrime(p) && isPrime(n-p)
+			}
+			assert.Equal(t, answer(expected), call(t, chunk, "GOLDBACH?", n).Format(vm.BooleanKind), "GOLDBACH?[%d]", n)
+		}
+	})
+
+	t.Run("FACTORIAL matches Go for the first inputs", func(t *testing.T) {
+		expected := big.NewInt(1)
+		for n := uint64(0); n < 300; n++ {
+			if n > 0 {
+				expected.Mul(expected, new(big.Int).SetUint64(n))
+			}
+			assert.Equal(t, expected.String(), call(t, chunk, "FACTORIAL", n).Big().String(), "FACTORIAL[%d]", n)
+		}
+	})
+
+	t.Run("TWO-TO-THE-THREE-TO-THE matches Go for the first inputs", func(t *testing.T) {
+		three := big.NewInt(3)
+		for n := uint64(0); n < 7; n++ {
+			exponent := new(big.Int).Exp(three, new(big.Int).SetUint64(n), nil)
+			expected := new(big.Int).Lsh(big.NewInt(1), uint(exponent.Uint64()))
+			assert.Equal(t, expected.String(), call(t, chunk, "TWO-TO-THE-THREE-TO-THE", n).Big().String(), "TWO-TO-THE-THREE-TO-THE[%d]", n)
+		}
+	})
+}
+
+// linked returns the names of the procedures of a program after compiling it
+// with the library, in the order they are defined
+func linked(t *testing.T, text string, names ...string) []string {
+	tokens, errs := compiler.Lex("test.bloop", text)
+	require.Nil(t, errs)
+
+	chunk, errs := stdlib.Compile(tokens, names...)
+	require.Nil(t, errs)
+
+	var res []string
+	for _, fn := range chunk.Procedures() {
+		res = append(res, fn.Name)
+	}
+	return res
+}
+
+func TestCompile(t *testing.T) {
+	t.Run("Programs that don't call the library are left alone", func(t *testing.T) {
+		assert.Nil(t, linked(t, "OUTPUT <- 1 + 2"))
+	})
+
+	t.Run("Called procedures come after the ones they call", func(t *testing.T) {
+		assert.Equal(t, []string{"MINUS", "REMAINDER", "DIVIDES?", "PRIME?"}, linked(t, "OUTPUT <- PRIME?[7]"))
+		assert.Equal(t, []string{"MINUS", "REMAINDER", "DIVIDES?", "PRIME?", "GOLDBACH?"}, linked(t, "OUTPUT <- GOLDBACH?[8] AND PRIME?[7]"))
+	})
+
+	t.Run("Calls inside procedures are linked", func(t *testing.T) {
+		assert.Equal(t, []string{"FACTORIAL", "ONE-MORE-FACTORIAL"}, linked(t, `
+			DEFINE PROCEDURE "ONE-MORE-FACTORIAL" [N]
+				OUTPUT <- FACTORIAL[N] + 1
+			END PROCEDURE
+		`))
+	})
+
+	t.Run("Compiled programs run with the procedures they call", func(t *testing.T) {
+		tokens, errs := compiler.Lex("test.bloop", "OUTPUT <- PRIME?[MINUS[100, 3]]")
+		require.Nil(t, errs)
+		chunk, errs := stdlib.Compile(tokens)
+		require.Nil(t, errs)
+
+		res, err := vm.New().Run(chunk)
+		require.Nil(t, err)
+		assert.Equal(t, "YES", res.Format(vm.BooleanKind))
+	})
+
+	t.Run("Procedures of the program hide the ones of the library", func(t *testing.T) {
+		text := `
+			DEFINE PROCEDURE "MINUS" [M, N]
+				OUTPUT <- 42
+			END PROCEDURE
+			OUTPUT <- MINUS[3, 1]
+		`
+		assert.Equal(t, []string{"MINUS"}, linked(t, text))
+
+		tokens, errs := compiler.Lex("test.bloop", text)
+		require.Nil(t, errs)
+		chunk, errs := stdlib.Compile(tokens)
+		require.Nil(t, errs)
+
+		res, err := vm.New().Run(chunk)
+		require.Nil(t, err)
+		assert.Equal(t, uint64(42), res.Big().Uint64())
+	})
+
+	t.Run("Procedures of the program can't hide the ones the library calls", func(t *testing.T) {
+		text := `
+			DEFINE PROCEDURE "REMAINDER" [N]
+				OUTPUT <- N
+			END PROCEDURE
+			OUTPUT <- PRIME?[9] AND REMAINDER[3] = 3
+		`
+		tokens, errs := compiler.Lex("test.bloop", text)
+		require.Nil(t, errs)
+
+		_, errs = stdlib.Compile(tokens)
+		require.Equal(t, 1, len(errs))
+		assert.ErrorIs(t, errs[0], stdlib.HiddenProcedureErrCode)
+
+		var d *compiler.Diagnostic
+		require.True(t, errors.As(errs[0], &d))
+		assert.Equal(t, "test.bloop", d.Span.Start.File)
+		assert.Equal(t, 2, d.Span.Start.Line)
+		assert.Equal(t, "procedure 'REMAINDER' hides the one of the standard library that 'DIVIDES?' calls", d.Message)
+
+		// Without calls into the library the program is fine
+		assert.Equal(t, []string{"REMAINDER"}, linked(t, `
+			DEFINE PROCEDURE "REMAINDER" [N]
+				OUTPUT <- N
+			END PROCEDURE
+			OUTPUT <- REMAINDER[3]
+		`))
+	})
+
+	t.Run("Names are linked even when nothing calls them", func(t *testing.T) {
+		assert.Equal(t, []string{"FACTORIAL"}, linked(t, "", "FACTORIAL", "UNKNOWN"))
+	})
+
+	t.Run("Source returns the files diagnostics point at", func(t *testing.T) {
+		text, ok := stdlib.Source("stdlib/primes.bloop")
+		require.True(t, ok)
+		assert.Contains(t, text, `DEFINE PROCEDURE "PRIME?" [N]`)
+
+		_, ok = stdlib.Source("primes.bloop")
+		assert.False(t, ok)
+		_, ok = stdlib.Source("stdlib/missing.bloop")
+		assert.False(t, ok)
+	})
+
+	t.Run("Names lists every procedure of the library", func(t *testing.T) {
+		assert.Equal(t, []string{
+			"DIVIDES?", "EQUAL?", "FACTORIAL", "GOLDBACH?", "MINUS", "PRIME?", "REMAINDER", "TWO-TO-THE-THREE-TO-THE",
+		}, stdlib.Names())
+	})
+}
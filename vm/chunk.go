@@ -1,35 +1,197 @@
 package vm
 
-import "errors"
+import (
+	"crypto/sha256"
+	"errors"
+)
 
 func NewChunk() Chunk {
 	return Chunk{
-		ip:           0,
 		instructions: []byte{},
-		locals:       map[byte]byte{},
 	}
 }
 
 // Chunk for instructions
 type Chunk struct {
-	ip           uint32
 	instructions []byte
 	line         []int
-	locals       map[byte]byte
-	localCount   int
+	constants    []Value
+	// constantIndex finds values already in the pool. Copies of the chunk
+	// share it, so its entries are checked against the pool before use
+	constantIndex map[constantKey]int
+	localCount    int
+	procedures    []*Function
+	output        Kind
+	sourceHash    []byte
+	longJumps     bool
 }
 
-func (c Chunk) InstructionsCount() int {
+func (c *Chunk) InstructionsCount() int {
 	return len(c.instructions)
 }
 
-func (c Chunk) AddLocal() byte {
+// Extend returns an empty chunk that keeps the locals and the procedure table
+// of c, so code compiled into it can go on using them
+func (c *Chunk) Extend() Chunk {
+	chunk := NewChunk()
+	chunk.localCount = c.localCount
+	chunk.procedures = append([]*Function{}, c.procedures...)
+	return chunk
+}
+
+// SetSource records the SHA-256 of the source the chunk was compiled from
+func (c *Chunk) SetSource(source []byte) {
+	hash := sha256.Sum256(source)
+	c.sourceHash = hash[:]
+}
+
+// SourceHash returns the SHA-256 of the source the chunk was compiled from,
+// if it was recorded
+func (c *Chunk) SourceHash() ([]byte, bool) {
+	return c.sourceHash, c.sourceHash != nil
+}
+
+// SetOutput records the kind of the value the chunk returns
+func (c *Chunk) SetOutput(k Kind) {
+	c.output = k
+}
+
+// Output returns the kind of the value the chunk returns
+func (c *Chunk) Output() Kind {
+	return c.output
+}
+
+// LocalsCount returns the amount of slots the chunk needs to store its locals
+func (c *Chunk) LocalsCount() int {
+	return c.localCount
+}
+
+// constantKey identifies a value of the constant pool
+type constantKey struct {
+	small uint64
+	big   string
+}
+
+func (v Value) key() constantKey {
+	if v.big == nil {
+		return constantKey{small: v.small}
+	}
+	return constantKey{big: v.big.String()}
+}
+
+// AddConstant adds a value to the constant pool of the chunk and returns its
+// index. Values already in the pool are reused
+func (c *Chunk) AddConstant(v Value) int {
+	if c.constantIndex == nil {
+		c.constantIndex = make(map[constantKey]int, len(c.constants))
+		for i, constant := range c.constants {
+			if _, ok := c.constantIndex[constant.key()]; !ok {
+				c.constantIndex[constant.key()] = i
+			}
+		}
+	}
+
+	key := v.key()
+	if i, ok := c.constantIndex[key]; ok && i < len(c.constants) && c.constants[i].cmp(v) == 0 {
+		return i
+	}
+
+	c.constants = append(c.constants, v)
+	c.constantIndex[key] = len(c.constants) - 1
+	return len(c.constants) - 1
+}
+
+// Constant returns the value stored at the given index of the constant pool
+func (c *Chunk) Constant(index int) Value {
+	return c.constants[index]
+}
+
+// ConstantsCount returns the size of the constant pool
+func (c *Chunk) ConstantsCount() int {
+	return len(c.constants)
+}
+
+// AddProcedure adds a function to the procedure table of the chunk and
+// returns its index
+func (c *Chunk) AddProcedure(fn *Function) int {
+	c.procedures = append(c.procedures, fn)
+	return len(c.procedures) - 1
+}
+
+// Procedures returns the procedure table of the chunk
+func (c *Chunk) Procedures() []*Function {
+	return c.procedures
+}
+
+// Procedure looks for a procedure by its name
+func (c *Chunk) Procedure(name string) (*Function, bool) {
+	for _, fn := range c.procedures {
+		if fn.Name == name {
+			return fn, true
+		}
+	}
+	return nil, false
+}
+
+// maxLocals is the amount of slots the long encodings of OpGet and OpSet can
+// address
+const maxLocals = 0xffff + 1
+
+// maxProcedures is the amount of procedures the operand of OpCall can address
+const maxProcedures = 0xffff + 1
+
+// EmitCall appends an OpCall instruction for the procedure with the index in
+// the procedure table
+func (c *Chunk) EmitCall(index int, line int) error {
+	if index >= maxProcedures {
+		return errors.New("too many procedures")
+	}
+	c.Append(OpCall.Byte(), line, byte(index>>8&0xff), byte(index&0xff))
+	return nil
+}
+
+// AddLocal reserves a new slot for a local and returns it
+func (c *Chunk) AddLocal() int {
+	slot := c.localCount
 	c.localCount++
-	c.locals[byte(c.localCount)] = 0x0
-	return byte(c.localCount)
+	return slot
+}
+
+// EmitConstant adds the value to the constant pool and appends the
+// instruction that pushes it, using the long encoding when its index doesn't
+// fit in a byte
+func (c *Chunk) EmitConstant(v Value, line int) error {
+	index := c.AddConstant(v)
+	switch {
+	case index <= 0xff:
+		c.Append(OpConstant.Byte(), line, byte(index))
+	case index <= 0xffffff:
+		c.Append(OpConstantLong.Byte(), line, byte(index>>16&0xff), byte(index>>8&0xff), byte(index&0xff))
+	default:
+		return errors.New("too many constants")
+	}
+	return nil
+}
+
+// EmitLocal appends an OpGet or OpSet instruction for the slot, switching to
+// its long encoding when the slot doesn't fit in a byte
+func (c *Chunk) EmitLocal(code OpCode, slot int, line int) error {
+	switch {
+	case slot <= 0xff:
+		c.Append(code.Byte(), line, byte(slot))
+	case slot < maxLocals:
+		long := OpGetLong
+		if code == OpSet {
+			long = OpSetLong
+		}
+		c.Append(long.Byte(), line, byte(slot>>8&0xff), byte(slot&0xff))
+	default:
+		return errors.New("too many locals")
+	}
+	return nil
 }
 
-func (c Chunk) Write(index int, b byte) error {
+func (c *Chunk) Write(index int, b byte) error {
 	if index >= len(c.instructions) {
 		panic("invalid chunk index")
 	}
@@ -38,27 +200,70 @@ func (c Chunk) Write(index int, b byte) error {
 	return nil
 }
 
-func (c Chunk) EmitJump(code OpCode, line int) int {
-	return c.Append(code.Byte(), line, 0xff, 0xff)
+// UseLongJumps makes every jump emitted from now on use a four byte offset
+func (c *Chunk) UseLongJumps() {
+	c.longJumps = true
 }
 
-func (c Chunk) PatchJump(offset int, to ...int) error {
-	jump := len(c.instructions) - 2 - offset
-	if jump > 256 {
+// EmitJump appends a forward jump instruction with a placeholder offset and
+// returns the index of the offset so it can be patched later
+func (c *Chunk) EmitJump(code OpCode, line int) int {
+	if !c.longJumps {
+		return c.Append(code.Byte(), line, 0xff, 0xff) - 1
+	}
+
+	switch code {
+	case OpJump:
+		code = OpJumpLong
+	case OpJumpIfFalse:
+		code = OpJumpIfFalseLong
+	}
+	return c.Append(code.Byte(), line, 0xff, 0xff, 0xff, 0xff) - 3
+}
+
+// PatchJump makes the jump whose offset is stored at the given index land on
+// the next instruction to be appended
+func (c *Chunk) PatchJump(offset int) error {
+	width := OpCode(c.instructions[offset-1]).Size() - 1
+	jump := len(c.instructions) - width - offset
+	if jump > maxOffset(width) {
 		return errors.New("block is too large")
 	}
 
-	if len(to) > 0 {
-		jump = to[0]
+	c.writeOperand(offset, width, jump)
+	return nil
+}
+
+// EmitLoop appends a backward jump to the instruction at the given index
+func (c *Chunk) EmitLoop(start int, line int) error {
+	code, width := OpLoop, 2
+	if c.longJumps {
+		code, width = OpLoopLong, 4
 	}
 
-	c.instructions[offset] = byte(jump >> 8 & 0xff)
-	c.instructions[offset+1] = byte(jump & 0xff)
+	jump := len(c.instructions) + 1 + width - start
+	if jump > maxOffset(width) {
+		return errors.New("block is too large")
+	}
 
+	offset := c.Append(code.Byte(), line, make([]byte, width)...) - width + 1
+	c.writeOperand(offset, width, jump)
 	return nil
 }
 
-func (c Chunk) Append(b byte, line int, more ...byte) int {
+func maxOffset(width int) int {
+	return 1<<(8*width) - 1
+}
+
+// writeOperand stores a big endian operand of the given width
+func (c *Chunk) writeOperand(offset int, width int, operand int) {
+	for i := width - 1; i >= 0; i-- {
+		c.instructions[offset+i] = byte(operand & 0xff)
+		operand >>= 8
+	}
+}
+
+func (c *Chunk) Append(b byte, line int, more ...byte) int {
 	c.instructions = append(c.instructions, b)
 	c.line = append(c.line, line)
 
@@ -72,6 +277,12 @@ func (c Chunk) Append(b byte, line int, more ...byte) int {
 	return len(c.instructions) - 1
 }
 
-func (c Chunk) Read() byte {
-	return c.instructions[c.ip]
+// Read returns the byte stored at the given offset
+func (c *Chunk) Read(offset int) byte {
+	return c.instructions[offset]
+}
+
+// Line returns the source line of the byte stored at the given offset
+func (c *Chunk) Line(offset int) int {
+	return c.line[offset]
 }
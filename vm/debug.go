@@ -0,0 +1,90 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Disassemble returns a human readable listing of the chunk instructions
+// followed by the listings of its procedures
+func Disassemble(chunk Chunk, name string) string {
+	var sb strings.Builder
+	disassemble(&sb, &chunk, chunk.procedures, name)
+
+	for _, fn := range chunk.procedures {
+		sb.WriteString("\n")
+		disassemble(&sb, &fn.Chunk, chunk.procedures, fn.Name)
+	}
+
+	return sb.String()
+}
+
+// DisassembleInstruction returns a human readable description of the
+// instruction at the given offset and the offset of the next instruction
+func DisassembleInstruction(chunk Chunk, offset int) (string, int) {
+	return disassembleInstruction(&chunk, chunk.procedures, offset)
+}
+
+func disassemble(sb *strings.Builder, chunk *Chunk, procedures []*Function, name string) {
+	sb.WriteString(fmt.Sprintf("== %s ==\n", name))
+	for offset := 0; offset < chunk.InstructionsCount(); {
+		var text string
+		text, offset = disassembleInstruction(chunk, procedures, offset)
+		sb.WriteString(text)
+		sb.WriteString("\n")
+	}
+}
+
+func disassembleInstruction(chunk *Chunk, procedures []*Function, offset int) (string, int) {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%04d ", offset))
+	if offset > 0 && chunk.Line(offset) == chunk.Line(offset-1) {
+		sb.WriteString("   | ")
+	} else {
+		sb.WriteString(fmt.Sprintf("%4d ", chunk.Line(offset)))
+	}
+
+	op := OpCode(chunk.Read(offset))
+	if !op.IsValid() {
+		sb.WriteString(fmt.Sprintf("%-21s %v", op.String(), op.Byte()))
+		return sb.String(), offset + 1
+	}
+
+	next := offset + op.Size()
+	if next > chunk.InstructionsCount() {
+		sb.WriteString(fmt.Sprintf("%-21s <truncated>", op.String()))
+		return sb.String(), chunk.InstructionsCount()
+	}
+
+	operand := 0
+	for i := offset + 1; i < next; i++ {
+		operand = operand<<8 | int(chunk.Read(i))
+	}
+
+	switch op {
+	case OpConstant, OpConstantLong:
+		value := "?"
+		if operand < chunk.ConstantsCount() {
+			value = chunk.Constant(operand).String()
+		}
+		sb.WriteString(fmt.Sprintf("%-21s %4d '%s'", op.String(), operand, value))
+	case OpJump, OpJumpLong, OpJumpIfFalse, OpJumpIfFalseLong:
+		sb.WriteString(fmt.Sprintf("%-21s %4d -> %d", op.String(), offset, next+operand))
+	case OpLoop, OpLoopLong:
+		sb.WriteString(fmt.Sprintf("%-21s %4d -> %d", op.String(), offset, next-operand))
+	case OpCall:
+		name := "?"
+		if operand < len(procedures) {
+			name = procedures[operand].Name
+		}
+		sb.WriteString(fmt.Sprintf("%-21s %4d '%s'", op.String(), operand, name))
+	default:
+		if len(op.Operands()) == 0 {
+			sb.WriteString(op.String())
+		} else {
+			sb.WriteString(fmt.Sprintf("%-21s %4d", op.String(), operand))
+		}
+	}
+
+	return sb.String(), next
+}
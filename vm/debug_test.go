@@ -0,0 +1,58 @@
+package vm_test
+
+import (
+	"github.com/gonzispina/gloop/vm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	t.Run("It lists every instruction with its line and operands", func(t *testing.T) {
+		double := &vm.Function{Name: "DOUBLE", Arity: 1, Chunk: vm.NewChunk()}
+		n := double.Chunk.AddLocal()
+		require.Nil(t, double.Chunk.EmitLocal(vm.OpGet, n, 2))
+		require.Nil(t, double.Chunk.EmitLocal(vm.OpGet, n, 2))
+		double.Chunk.Append(vm.OpAdd.Byte(), 2)
+		double.Chunk.Append(vm.OpReturn.Byte(), 3)
+
+		chunk := vm.NewChunk()
+		index := chunk.AddProcedure(double)
+		slot := chunk.AddLocal()
+		require.Nil(t, chunk.EmitConstant(vm.NewValue(1000), 5))
+		jump := chunk.EmitJump(vm.OpJumpIfFalse, 5)
+		chunk.Append(vm.OpPop.Byte(), 5)
+		chunk.Append(vm.OpCall.Byte(), 6, byte(index>>8), byte(index))
+		require.Nil(t, chunk.EmitLocal(vm.OpSet, slot, 6))
+		require.Nil(t, chunk.EmitLoop(0, 7))
+		require.Nil(t, chunk.PatchJump(jump))
+		chunk.Append(vm.OpReturn.Byte(), 8)
+
+		expected := "== script ==\n" +
+			"0000    5 OP_CONSTANT              0 '1000'\n" +
+			"0002    | OP_JUMP_IF_FALSE         2 -> 14\n" +
+			"0005    | OP_POP\n" +
+			"0006    6 OP_CALL                  0 'DOUBLE'\n" +
+			"0009    | OP_SET                   0\n" +
+			"0011    7 OP_LOOP                 11 -> 0\n" +
+			"0014    8 OP_RETURN\n" +
+			"\n" +
+			"== DOUBLE ==\n" +
+			"0000    2 OP_GET                   0\n" +
+			"0002    | OP_GET                   0\n" +
+			"0004    | OP_ADD\n" +
+			"0005    3 OP_RETURN\n"
+
+		assert.Equal(t, expected, vm.Disassemble(chunk, "script"))
+	})
+
+	t.Run("It decodes a single instruction and returns the next offset", func(t *testing.T) {
+		chunk := vm.NewChunk()
+		chunk.Append(vm.OpPush.Byte(), 1, 3)
+		chunk.Append(vm.OpReturn.Byte(), 1)
+
+		text, next := vm.DisassembleInstruction(chunk, 0)
+		assert.Equal(t, "0000    1 OP_PUSH                  3", text)
+		assert.Equal(t, 2, next)
+	})
+}
@@ -0,0 +1,74 @@
+package vm
+
+import (
+	"fmt"
+)
+
+type ErrCode string
+
+const (
+	StackUnderflowErrCode     = "Stack underflow"
+	UnknownInstructionErrCode = "Unknown instruction"
+	InvalidSlotErrCode        = "Invalid slot"
+	UndefinedProcedureErrCode = "Undefined procedure"
+	WrongArgumentCountErrCode = "Wrong argument count"
+	InvalidConstantErrCode    = "Invalid constant"
+	CellOutOfRangeErrCode     = "Cell out of range"
+	CallTooDeepErrCode        = "Call too deep"
+)
+
+// RuntimeError is returned when the VM fails to execute an instruction
+type RuntimeError struct {
+	Line    int
+	Message string
+	Code    ErrCode
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("Line %v: %s. ErrCode: %s", e.Line, e.Message, string(e.Code))
+}
+
+func runtimeErr(line int, message string, code ErrCode) error {
+	return &RuntimeError{Line: line, Message: message, Code: code}
+}
+
+func stackUnderflowErr(line int) error {
+	return runtimeErr(line, "stack underflow", StackUnderflowErrCode)
+}
+
+func unknownInstructionErr(line int, b byte) error {
+	return runtimeErr(line, fmt.Sprintf("unknown instruction '%v'", b), UnknownInstructionErrCode)
+}
+
+func invalidSlotErr(line int, slot int) error {
+	return runtimeErr(line, fmt.Sprintf("invalid local slot '%v'", slot), InvalidSlotErrCode)
+}
+
+func invalidProcedureErr(line int, index int) error {
+	return runtimeErr(line, fmt.Sprintf("invalid procedure index '%v'", index), UndefinedProcedureErrCode)
+}
+
+func undefinedProcedureErr(line int, name string) error {
+	return runtimeErr(line, fmt.Sprintf("undefined procedure '%s'", name), UndefinedProcedureErrCode)
+}
+
+func wrongArgumentCountErr(line int, fn *Function, got int) error {
+	return runtimeErr(line, fmt.Sprintf(
+		"procedure '%s' expects %v arguments but got %v",
+		fn.Name,
+		fn.Arity,
+		got,
+	), WrongArgumentCountErrCode)
+}
+
+func invalidConstantErr(line int, index int) error {
+	return runtimeErr(line, fmt.Sprintf("invalid constant index '%v'", index), InvalidConstantErrCode)
+}
+
+func cellOutOfRangeErr(line int, index Value) error {
+	return runtimeErr(line, fmt.Sprintf("cell index '%s' is out of range", index), CellOutOfRangeErrCode)
+}
+
+func callTooDeepErr(line int, fn *Function) error {
+	return runtimeErr(line, fmt.Sprintf("call to procedure '%s' nests deeper than any program can", fn.Name), CallTooDeepErrCode)
+}
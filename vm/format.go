@@ -0,0 +1,340 @@
+package vm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/big"
+)
+
+// FormatVersion is the version of the bytecode files written by WriteChunk
+const FormatVersion = 1
+
+// magic identifies gloop bytecode files
+var magic = [4]byte{'G', 'L', 'B', 'C'}
+
+const (
+	// header: magic, version, flags and body length
+	headerSize = len(magic) + 2 + 1 + 4
+	// trailer: CRC-32 of the header, source hash and body
+	trailerSize = 4
+
+	flagSourceHash = 1 << 0
+)
+
+var (
+	ErrNotBytecode        = errors.New("not a gloop bytecode file")
+	ErrUnsupportedVersion = errors.New("unsupported bytecode version")
+	ErrTruncated          = errors.New("bytecode file is truncated")
+	ErrCorrupted          = errors.New("bytecode file is corrupted")
+)
+
+// WriteChunk encodes the chunk and its procedures. The file starts with a
+// header holding the magic bytes, the format version, a set of flags and the
+// length of the body, optionally followed by the SHA-256 of the source. The
+// body has the script followed by the procedure table, and a CRC-32 of
+// everything closes the file.
+//
+// Every chunk is encoded as its constant pool, its amount of locals, the kind
+// of its output, its instructions and its line table compressed as runs of
+// instructions bytes sharing a line. Numbers are written as unsigned varints.
+func WriteChunk(w io.Writer, chunk Chunk) error {
+	var body bytes.Buffer
+	e := &encoder{w: &body}
+	e.chunk(&chunk)
+	e.uint(uint64(len(chunk.procedures)))
+	for _, fn := range chunk.procedures {
+		e.bytes([]byte(fn.Name))
+		e.uint(uint64(fn.Arity))
+		e.chunk(&fn.Chunk)
+	}
+
+	var file bytes.Buffer
+	file.Write(magic[:])
+	file.Write(binary.BigEndian.AppendUint16(nil, FormatVersion))
+
+	var flags byte
+	if chunk.sourceHash != nil {
+		flags |= flagSourceHash
+	}
+	file.WriteByte(flags)
+	file.Write(binary.BigEndian.AppendUint32(nil, uint32(body.Len())))
+
+	if chunk.sourceHash != nil {
+		file.Write(chunk.sourceHash)
+	}
+	file.Write(body.Bytes())
+	file.Write(binary.BigEndian.AppendUint32(nil, crc32.ChecksumIEEE(file.Bytes())))
+
+	_, err := w.Write(file.Bytes())
+	return err
+}
+
+// ReadChunk decodes a chunk written by WriteChunk. It rejects files with
+// another format version, truncated or corrupted files and chunks with
+// instructions that cannot run
+func ReadChunk(r io.Reader) (Chunk, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Chunk{}, err
+	}
+
+	if len(data) < len(magic) || !bytes.Equal(data[:len(magic)], magic[:]) {
+		return Chunk{}, ErrNotBytecode
+	}
+
+	if len(data) < headerSize {
+		return Chunk{}, ErrTruncated
+	}
+
+	version := binary.BigEndian.Uint16(data[len(magic):])
+	if version != FormatVersion {
+		return Chunk{}, fmt.Errorf("%w: got version %v, expected %v", ErrUnsupportedVersion, version, FormatVersion)
+	}
+
+	flags := data[len(magic)+2]
+	hashSize := 0
+	if flags&flagSourceHash != 0 {
+		hashSize = sha256.Size
+	}
+
+	length := int(binary.BigEndian.Uint32(data[len(magic)+3:]))
+	size := headerSize + hashSize + length + trailerSize
+	if len(data) < size {
+		return Chunk{}, fmt.Errorf("%w: expected %v bytes but got %v", ErrTruncated, size, len(data))
+	}
+	if len(data) > size {
+		return Chunk{}, fmt.Errorf("%w: %v unexpected bytes after the end", ErrCorrupted, len(data)-size)
+	}
+
+	checksum := binary.BigEndian.Uint32(data[size-trailerSize:])
+	if crc32.ChecksumIEEE(data[:size-trailerSize]) != checksum {
+		return Chunk{}, fmt.Errorf("%w: checksum mismatch", ErrCorrupted)
+	}
+
+	d := &decoder{r: bytes.NewReader(data[headerSize+hashSize : size-trailerSize])}
+	chunk := d.chunk()
+	procedures := d.count()
+	for i := 0; i < procedures && d.err == nil; i++ {
+		fn := &Function{Name: string(d.bytes())}
+		arity := d.uint()
+		fn.Chunk = d.chunk()
+		// Checked before the conversion so huge arities can't wrap around
+		if d.err == nil && arity > uint64(fn.Chunk.localCount) {
+			d.fail(fmt.Sprintf("procedure '%s' has more parameters than locals", fn.Name))
+		}
+		fn.Arity = int(arity)
+		chunk.procedures = append(chunk.procedures, fn)
+	}
+
+	if d.err == nil && d.r.Len() != 0 {
+		d.fail("unexpected bytes after the procedure table")
+	}
+	if d.err != nil {
+		return Chunk{}, d.err
+	}
+
+	if hashSize != 0 {
+		chunk.sourceHash = append([]byte{}, data[headerSize:headerSize+hashSize]...)
+	}
+
+	if err := chunk.validate(len(chunk.procedures)); err != nil {
+		return Chunk{}, err
+	}
+	for _, fn := range chunk.procedures {
+		if err := fn.Chunk.validate(len(chunk.procedures)); err != nil {
+			return Chunk{}, fmt.Errorf("procedure '%s': %w", fn.Name, err)
+		}
+	}
+
+	return chunk, nil
+}
+
+type encoder struct {
+	w *bytes.Buffer
+}
+
+func (e *encoder) uint(n uint64) {
+	e.w.Write(binary.AppendUvarint(nil, n))
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uint(uint64(len(b)))
+	e.w.Write(b)
+}
+
+func (e *encoder) chunk(c *Chunk) {
+	e.uint(uint64(len(c.constants)))
+	for _, constant := range c.constants {
+		e.bytes(constant.Big().Bytes())
+	}
+
+	e.uint(uint64(c.localCount))
+	e.uint(uint64(c.output))
+	e.bytes(c.instructions)
+
+	var runs [][2]int
+	for _, line := range c.line {
+		if len(runs) > 0 && runs[len(runs)-1][0] == line {
+			runs[len(runs)-1][1]++
+		} else {
+			runs = append(runs, [2]int{line, 1})
+		}
+	}
+
+	e.uint(uint64(len(runs)))
+	for _, run := range runs {
+		e.uint(uint64(run[0]))
+		e.uint(uint64(run[1]))
+	}
+}
+
+type decoder struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (d *decoder) fail(message string) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %s", ErrCorrupted, message)
+	}
+}
+
+func (d *decoder) uint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	n, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		d.fail("invalid number")
+	}
+	return n
+}
+
+// count reads a length and checks it isn't larger than the bytes left
+func (d *decoder) count() int {
+	n := d.uint()
+	if n > uint64(d.r.Len()) {
+		d.fail("length out of range")
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.count()
+	if d.err != nil {
+		return nil
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		d.fail("unexpected end of body")
+	}
+	return b
+}
+
+func (d *decoder) chunk() Chunk {
+	c := NewChunk()
+
+	constants := d.count()
+	for i := 0; i < constants && d.err == nil; i++ {
+		c.constants = append(c.constants, BigValue(new(big.Int).SetBytes(d.bytes())))
+	}
+
+	// Frames allocate every local up front, so the amount can't be trusted
+	// past what the instructions can address
+	locals := d.uint()
+	if locals > maxLocals {
+		d.fail("too many locals")
+		return c
+	}
+	c.localCount = int(locals)
+	c.output = Kind(d.uint())
+	if c.output != NumberKind && c.output != BooleanKind {
+		d.fail("invalid output kind")
+	}
+	c.instructions = d.bytes()
+
+	runs := d.count()
+	for i := 0; i < runs && d.err == nil; i++ {
+		line, count := int(d.uint()), d.uint()
+		if count > uint64(len(c.instructions)-len(c.line)) {
+			d.fail("line table doesn't match the instructions")
+			break
+		}
+		for j := uint64(0); j < count; j++ {
+			c.line = append(c.line, line)
+		}
+	}
+
+	if d.err == nil && len(c.line) != len(c.instructions) {
+		d.fail("line table doesn't match the instructions")
+	}
+
+	return c
+}
+
+// validate checks that every instruction of the chunk is known and that its
+// operands point inside the chunk. Jumps must land on the start of an
+// instruction or on the end of the chunk
+func (c *Chunk) validate(procedures int) error {
+	starts := make([]bool, len(c.instructions)+1)
+	type jump struct {
+		op     OpCode
+		offset int
+		target int
+	}
+	var jumps []jump
+
+	for offset := 0; offset < len(c.instructions); {
+		starts[offset] = true
+		op := OpCode(c.instructions[offset])
+		if !op.IsValid() {
+			return fmt.Errorf("%w: unknown instruction %v at %v", ErrCorrupted, op.Byte(), offset)
+		}
+
+		next := offset + op.Size()
+		if next > len(c.instructions) {
+			return fmt.Errorf("%w: truncated %s at %v", ErrCorrupted, op, offset)
+		}
+
+		operand := 0
+		for i := offset + 1; i < next; i++ {
+			operand = operand<<8 | int(c.instructions[i])
+		}
+
+		valid := true
+		switch op {
+		case OpConstant, OpConstantLong:
+			valid = operand < len(c.constants)
+		case OpGet, OpSet, OpGetLong, OpSetLong:
+			valid = operand < c.localCount
+		case OpCall:
+			valid = operand < procedures
+		case OpJump, OpJumpIfFalse, OpJumpLong, OpJumpIfFalseLong:
+			jumps = append(jumps, jump{op, offset, next + operand})
+		case OpLoop, OpLoopLong:
+			jumps = append(jumps, jump{op, offset, next - operand})
+		}
+
+		if !valid {
+			return fmt.Errorf("%w: %s at %v has an invalid operand %v", ErrCorrupted, op, offset, operand)
+		}
+		offset = next
+	}
+	starts[len(c.instructions)] = true
+
+	for _, j := range jumps {
+		if j.target < 0 || j.target >= len(starts) || !starts[j.target] {
+			return fmt.Errorf("%w: %s at %v jumps to %v, which isn't the start of an instruction", ErrCorrupted, j.op, j.offset, j.target)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,223 @@
+package vm_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"github.com/gonzispina/gloop/vm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"hash/crc32"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func program(t *testing.T) vm.Chunk {
+	square := &vm.Function{Name: "SQUARE", Arity: 1, Chunk: vm.NewChunk()}
+	n := square.Chunk.AddLocal()
+	require.Nil(t, square.Chunk.EmitLocal(vm.OpGet, n, 2))
+	require.Nil(t, square.Chunk.EmitLocal(vm.OpGet, n, 2))
+	square.Chunk.Append(vm.OpMultiply.Byte(), 2)
+	square.Chunk.Append(vm.OpReturn.Byte(), 3)
+
+	huge, _ := new(big.Int).SetString("1267650600228229401496703205376", 10)
+	chunk := vm.NewChunk()
+	index := chunk.AddProcedure(square)
+	require.Nil(t, chunk.EmitConstant(vm.BigValue(huge), 5))
+	chunk.Append(vm.OpCall.Byte(), 5, byte(index>>8), byte(index))
+	chunk.Append(vm.OpReturn.Byte(), 6)
+	return chunk
+}
+
+// craftChunk appends to body a chunk with the given amount of locals and
+// instructions, all of them on line 1
+func craftChunk(body []byte, locals uint64, code ...byte) []byte {
+	body = binary.AppendUvarint(body, 0)
+	body = binary.AppendUvarint(body, locals)
+	body = binary.AppendUvarint(body, uint64(vm.NumberKind))
+	body = binary.AppendUvarint(body, uint64(len(code)))
+	body = append(body, code...)
+	body = binary.AppendUvarint(body, 1)
+	body = binary.AppendUvarint(body, 1)
+	return binary.AppendUvarint(body, uint64(len(code)))
+}
+
+// seal wraps the body in a header and a valid checksum
+func seal(body []byte) []byte {
+	file := []byte("GLBC")
+	file = binary.BigEndian.AppendUint16(file, vm.FormatVersion)
+	file = append(file, 0)
+	file = binary.BigEndian.AppendUint32(file, uint32(len(body)))
+	file = append(file, body...)
+	return binary.BigEndian.AppendUint32(file, crc32.ChecksumIEEE(file))
+}
+
+// craft builds a file holding a script that only returns and declares the
+// given amount of locals, with a valid checksum
+func craft(locals uint64) []byte {
+	body := craftChunk(nil, locals, vm.OpReturn.Byte())
+	return seal(binary.AppendUvarint(body, 0))
+}
+
+// squareV1 is 'OUTPUT <- SQ[7]' with SQ squaring its argument, as written by
+// the first release of the format. Opcodes must keep their values for it to
+// still run
+const squareV1 = "474c42430001010000002bd41e2d2d4bde312115528ea40535758124ff63" +
+	"b0fc96b128e218f61a91fb45580001000a06071400001000110015020407" +
+	"000301025351010002000a110011000110011101150201070203979a8569"
+
+func encode(t *testing.T, chunk vm.Chunk) []byte {
+	var buf bytes.Buffer
+	require.Nil(t, vm.WriteChunk(&buf, chunk))
+	return buf.Bytes()
+}
+
+func TestWriteChunk(t *testing.T) {
+	t.Run("Chunks survive a round trip and still run", func(t *testing.T) {
+		chunk := program(t)
+
+		decoded, err := vm.ReadChunk(bytes.NewReader(encode(t, chunk)))
+		require.Nil(t, err)
+		assert.Equal(t, vm.Disassemble(chunk, "script"), vm.Disassemble(decoded, "script"))
+
+		expected, err := vm.New().Run(chunk)
+		require.Nil(t, err)
+		res, err := vm.New().Run(decoded)
+		require.Nil(t, err)
+		assert.Equal(t, expected.String(), res.String())
+	})
+
+	t.Run("The source hash is optional", func(t *testing.T) {
+		chunk := program(t)
+		decoded, err := vm.ReadChunk(bytes.NewReader(encode(t, chunk)))
+		require.Nil(t, err)
+		_, ok := decoded.SourceHash()
+		assert.False(t, ok)
+
+		chunk.SetSource([]byte("OUTPUT <- 1"))
+		decoded, err = vm.ReadChunk(bytes.NewReader(encode(t, chunk)))
+		require.Nil(t, err)
+		hash, ok := decoded.SourceHash()
+		assert.True(t, ok)
+		expected := sha256.Sum256([]byte("OUTPUT <- 1"))
+		assert.Equal(t, expected[:], hash)
+	})
+
+	t.Run("Files written by an earlier build of the same version still run", func(t *testing.T) {
+		data, err := hex.DecodeString(squareV1)
+		require.Nil(t, err)
+
+		chunk, err := vm.ReadChunk(bytes.NewReader(data))
+		require.Nil(t, err)
+		res, err := vm.New().Run(chunk)
+		require.Nil(t, err)
+		assert.Equal(t, "49", res.String())
+	})
+
+	t.Run("Files without the magic bytes are rejected", func(t *testing.T) {
+		_, err := vm.ReadChunk(bytes.NewReader([]byte("OUTPUT <- 1")))
+		assert.True(t, errors.Is(err, vm.ErrNotBytecode))
+	})
+
+	t.Run("Files from another version are rejected", func(t *testing.T) {
+		data := encode(t, program(t))
+		data[5] = vm.FormatVersion + 1
+
+		_, err := vm.ReadChunk(bytes.NewReader(data))
+		assert.True(t, errors.Is(err, vm.ErrUnsupportedVersion))
+	})
+
+	t.Run("Truncated files are rejected", func(t *testing.T) {
+		data := encode(t, program(t))
+		for _, size := range []int{6, len(data) / 2, len(data) - 1} {
+			_, err := vm.ReadChunk(bytes.NewReader(data[:size]))
+			assert.True(t, errors.Is(err, vm.ErrTruncated), "size %v: %v", size, err)
+		}
+	})
+
+	t.Run("Corrupted files are rejected", func(t *testing.T) {
+		data := encode(t, program(t))
+		for i := 11; i < len(data); i++ {
+			corrupted := append([]byte{}, data...)
+			corrupted[i] ^= 0x5a
+
+			_, err := vm.ReadChunk(bytes.NewReader(corrupted))
+			assert.True(t, errors.Is(err, vm.ErrCorrupted), "byte %v: %v", i, err)
+		}
+	})
+
+	t.Run("Files declaring more locals than can be addressed are rejected", func(t *testing.T) {
+		chunk, err := vm.ReadChunk(bytes.NewReader(craft(0xffff + 1)))
+		require.Nil(t, err)
+		assert.Equal(t, 0xffff+1, chunk.LocalsCount())
+
+		for _, locals := range []uint64{0xffff + 2, 1 << 40, 1 << 63, math.MaxUint64} {
+			_, err := vm.ReadChunk(bytes.NewReader(craft(locals)))
+			assert.True(t, errors.Is(err, vm.ErrCorrupted), "locals %v: %v", locals, err)
+		}
+	})
+
+	t.Run("Procedures with more parameters than locals are rejected", func(t *testing.T) {
+		for _, arity := range []uint64{2, 1<<63 + 5, math.MaxUint64} {
+			body := craftChunk(nil, 0, vm.OpReturn.Byte())
+			body = binary.AppendUvarint(body, 1)
+			body = binary.AppendUvarint(body, 1)
+			body = append(body, 'P')
+			body = binary.AppendUvarint(body, arity)
+			body = craftChunk(body, 1, vm.OpReturn.Byte())
+
+			_, err := vm.ReadChunk(bytes.NewReader(seal(body)))
+			assert.True(t, errors.Is(err, vm.ErrCorrupted), "arity %v: %v", arity, err)
+		}
+	})
+
+	t.Run("Jumps into the middle of an instruction are rejected", func(t *testing.T) {
+		for _, code := range [][]byte{
+			{vm.OpPush.Byte(), 1, vm.OpLoop.Byte(), 0, 2, vm.OpReturn.Byte()},
+			{vm.OpJump.Byte(), 0, 1, vm.OpPush.Byte(), 0, vm.OpReturn.Byte()},
+			{vm.OpPush.Byte(), 1, vm.OpLoopLong.Byte(), 0, 0, 0, 3, vm.OpReturn.Byte()},
+		} {
+			body := binary.AppendUvarint(craftChunk(nil, 0, code...), 0)
+
+			_, err := vm.ReadChunk(bytes.NewReader(seal(body)))
+			assert.True(t, errors.Is(err, vm.ErrCorrupted), "code %v: %v", code, err)
+		}
+
+		body := craftChunk(nil, 0, vm.OpPush.Byte(), 1, vm.OpJump.Byte(), 0, 1, vm.OpPop.Byte(), vm.OpReturn.Byte())
+		_, err := vm.ReadChunk(bytes.NewReader(seal(binary.AppendUvarint(body, 0))))
+		assert.Nil(t, err)
+	})
+
+	t.Run("Procedures calling themselves stop at the deepest a program can go", func(t *testing.T) {
+		call := []byte{vm.OpCall.Byte(), 0, 0, vm.OpReturn.Byte()}
+		body := craftChunk(nil, 0, call...)
+		body = binary.AppendUvarint(body, 1)
+		body = binary.AppendUvarint(body, 1)
+		body = append(body, 'P')
+		body = binary.AppendUvarint(body, 0)
+		body = craftChunk(body, 0, call...)
+
+		chunk, err := vm.ReadChunk(bytes.NewReader(seal(body)))
+		require.Nil(t, err)
+
+		_, err = vm.New().Run(chunk)
+		assertErrCode(t, err, vm.CallTooDeepErrCode)
+	})
+
+	t.Run("Chunks with invalid operands are rejected", func(t *testing.T) {
+		chunk := vm.NewChunk()
+		chunk.Append(vm.OpGet.Byte(), 1, 3)
+
+		_, err := vm.ReadChunk(bytes.NewReader(encode(t, chunk)))
+		assert.True(t, errors.Is(err, vm.ErrCorrupted))
+
+		chunk = vm.NewChunk()
+		chunk.Append(vm.OpCall.Byte(), 1, 0, 1)
+
+		_, err = vm.ReadChunk(bytes.NewReader(encode(t, chunk)))
+		assert.True(t, errors.Is(err, vm.ErrCorrupted))
+	})
+}
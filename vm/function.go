@@ -0,0 +1,8 @@
+package vm
+
+// Function is a compiled procedure
+type Function struct {
+	Name  string
+	Arity int
+	Chunk Chunk
+}
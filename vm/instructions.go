@@ -7,18 +7,123 @@ func (o OpCode) Byte() byte {
 }
 
 const (
+	// OpAdd pops b and a and pushes a + b
 	OpAdd OpCode = iota
+	// OpMultiply pops b and a and pushes a * b
 	OpMultiply
+	// OpEqual pops b and a and pushes YES if a = b
 	OpEqual
+	// OpGreater pops b and a and pushes YES if a > b
 	OpGreater
+	// OpLesser pops b and a and pushes YES if a < b
 	OpLesser
+	// OpNot pops a and pushes YES if a is NO
 	OpNot
 
+	// OpPush pushes its one byte operand
 	OpPush
+	// OpConstant pushes the constant whose index in the constant pool is
+	// given by its one byte operand
+	OpConstant
+	// OpConstantLong works like OpConstant with a three byte operand
+	OpConstantLong
+	// OpPop discards the value on top of the stack
 	OpPop
+	// OpJump moves forward as many bytes as its two byte operand says
 	OpJump
+	// OpJumpIfFalse jumps like OpJump when the value on top of the stack is
+	// NO. The value is left on the stack
 	OpJumpIfFalse
+	// OpJumpLong works like OpJump with a four byte operand
+	OpJumpLong
+	// OpJumpIfFalseLong works like OpJumpIfFalse with a four byte operand
+	OpJumpIfFalseLong
+	// OpLoop moves backwards as many bytes as its two byte operand says
+	OpLoop
+	// OpLoopLong works like OpLoop with a four byte operand
+	OpLoopLong
 
+	// OpSet pops a value and stores it in the slot given by its operand
 	OpSet
+	// OpGet pushes the value stored in the slot given by its operand
 	OpGet
+	// OpSetLong works like OpSet with a two byte operand
+	OpSetLong
+	// OpGetLong works like OpGet with a two byte operand
+	OpGetLong
+
+	// OpCall calls the procedure whose index in the procedure table is given
+	// by its two byte operand. The arguments are popped from the stack and
+	// become the first locals of the procedure
+	OpCall
+	// OpReturn pops a value, leaves the current procedure and pushes the
+	// value onto the caller's stack
+	OpReturn
+
+	// OpSetCell pops a value and an index and stores the value in the cell
+	// of the current procedure with that index
+	OpSetCell
+	// OpGetCell pops an index and pushes the value of the cell of the
+	// current procedure with that index. Cells never set hold 0
+	OpGetCell
 )
+
+// instruction describes how an OpCode is encoded
+type instruction struct {
+	name     string
+	operands []int
+}
+
+var instructions = map[OpCode]instruction{
+	OpAdd:             {"OP_ADD", nil},
+	OpMultiply:        {"OP_MULTIPLY", nil},
+	OpEqual:           {"OP_EQUAL", nil},
+	OpGreater:         {"OP_GREATER", nil},
+	OpLesser:          {"OP_LESSER", nil},
+	OpNot:             {"OP_NOT", nil},
+	OpPush:            {"OP_PUSH", []int{1}},
+	OpConstant:        {"OP_CONSTANT", []int{1}},
+	OpConstantLong:    {"OP_CONSTANT_LONG", []int{3}},
+	OpPop:             {"OP_POP", nil},
+	OpJump:            {"OP_JUMP", []int{2}},
+	OpJumpIfFalse:     {"OP_JUMP_IF_FALSE", []int{2}},
+	OpJumpLong:        {"OP_JUMP_LONG", []int{4}},
+	OpJumpIfFalseLong: {"OP_JUMP_IF_FALSE_LONG", []int{4}},
+	OpLoop:            {"OP_LOOP", []int{2}},
+	OpLoopLong:        {"OP_LOOP_LONG", []int{4}},
+	OpSet:             {"OP_SET", []int{1}},
+	OpGet:             {"OP_GET", []int{1}},
+	OpSetLong:         {"OP_SET_LONG", []int{2}},
+	OpGetLong:         {"OP_GET_LONG", []int{2}},
+	OpCall:            {"OP_CALL", []int{2}},
+	OpReturn:          {"OP_RETURN", nil},
+	OpSetCell:         {"OP_SET_CELL", nil},
+	OpGetCell:         {"OP_GET_CELL", nil},
+}
+
+// IsValid reports whether the byte is a known instruction
+func (o OpCode) IsValid() bool {
+	_, ok := instructions[o]
+	return ok
+}
+
+func (o OpCode) String() string {
+	if i, ok := instructions[o]; ok {
+		return i.name
+	}
+	return "OP_UNKNOWN"
+}
+
+// Operands returns the width in bytes of each operand of the instruction
+func (o OpCode) Operands() []int {
+	return instructions[o].operands
+}
+
+// Size returns the amount of bytes the instruction takes, operands included
+func (o OpCode) Size() int {
+	size := 1
+	for _, width := range o.Operands() {
+		size += width
+	}
+	return size
+}
@@ -0,0 +1,119 @@
+package vm
+
+import (
+	"math/big"
+	"math/bits"
+	"strconv"
+)
+
+// Value is a natural number. Booleans are represented as 0 (NO) and 1 (YES).
+// Numbers that fit in 64 bits are stored inline and promoted to big integers
+// when an operation overflows
+type Value struct {
+	small uint64
+	big   *big.Int
+}
+
+// Kind is the static type of a value
+type Kind uint8
+
+const (
+	NumberKind Kind = iota
+	BooleanKind
+)
+
+// NewValue returns the value of a small natural number
+func NewValue(n uint64) Value {
+	return Value{small: n}
+}
+
+// BigValue returns the value of an arbitrarily large natural number
+func BigValue(n *big.Int) Value {
+	if n.IsUint64() {
+		return Value{small: n.Uint64()}
+	}
+	return Value{big: new(big.Int).Set(n)}
+}
+
+func boolValue(b bool) Value {
+	if b {
+		return NewValue(1)
+	}
+	return NewValue(0)
+}
+
+// IsSmall reports whether the value fits in 64 bits
+func (v Value) IsSmall() bool {
+	return v.big == nil
+}
+
+// Uint64 returns the value when it fits in 64 bits
+func (v Value) Uint64() (uint64, bool) {
+	return v.small, v.big == nil
+}
+
+// Big returns the value as a big integer
+func (v Value) Big() *big.Int {
+	if v.big == nil {
+		return new(big.Int).SetUint64(v.small)
+	}
+	return new(big.Int).Set(v.big)
+}
+
+func (v Value) isFalse() bool {
+	return v.big == nil && v.small == 0
+}
+
+func (v Value) add(o Value) Value {
+	if v.big == nil && o.big == nil {
+		sum, carry := bits.Add64(v.small, o.small, 0)
+		if carry == 0 {
+			return NewValue(sum)
+		}
+	}
+	return BigValue(new(big.Int).Add(v.Big(), o.Big()))
+}
+
+func (v Value) multiply(o Value) Value {
+	if v.big == nil && o.big == nil {
+		hi, lo := bits.Mul64(v.small, o.small)
+		if hi == 0 {
+			return NewValue(lo)
+		}
+	}
+	return BigValue(new(big.Int).Mul(v.Big(), o.Big()))
+}
+
+// cmp returns -1, 0 or 1 depending on whether v is lesser, equal or greater
+// than o
+func (v Value) cmp(o Value) int {
+	if v.big == nil && o.big == nil {
+		switch {
+		case v.small < o.small:
+			return -1
+		case v.small > o.small:
+			return 1
+		default:
+			return 0
+		}
+	}
+	return v.Big().Cmp(o.Big())
+}
+
+func (v Value) String() string {
+	if v.big == nil {
+		return strconv.FormatUint(v.small, 10)
+	}
+	return v.big.String()
+}
+
+// Format returns the value as BlooP writes values of the given kind
+func (v Value) Format(k Kind) string {
+	if k == BooleanKind {
+		if v.isFalse() {
+			return "NO"
+		}
+		return "YES"
+	}
+	return v.String()
+}
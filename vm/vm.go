@@ -0,0 +1,387 @@
+package vm
+
+func New() *VM {
+	return &VM{
+		stack: []Value{},
+	}
+}
+
+// frame is the activation record of a running function
+type frame struct {
+	function *Function
+	ip       int
+	slots    []Value
+	cells    cells
+}
+
+// maxCells bounds the cells a procedure can use
+const maxCells = 1 << 24
+
+// denseCells is the amount of cells kept in a slice. Cells past it are kept
+// in a map, so a high index only costs the cells actually written
+const denseCells = 1 << 10
+
+// cells are the CELL(N) of a frame, all of them zero until written
+type cells struct {
+	dense  []Value
+	sparse map[int]Value
+}
+
+func (c *cells) get(index int) Value {
+	if index < len(c.dense) {
+		return c.dense[index]
+	}
+	return c.sparse[index]
+}
+
+func (c *cells) set(index int, v Value) {
+	if index >= denseCells {
+		if c.sparse == nil {
+			c.sparse = map[int]Value{}
+		}
+		c.sparse[index] = v
+		return
+	}
+
+	for len(c.dense) <= index {
+		c.dense = append(c.dense, Value{})
+	}
+	c.dense[index] = v
+}
+
+// VM executes the instructions of a Chunk
+type VM struct {
+	procedures []*Function
+	frames     []*frame
+	frame      *frame
+	stack      []Value
+
+	// locals and cells of the last script run, kept so Continue can resume
+	// from them
+	locals []Value
+	cells  cells
+}
+
+// Run executes every instruction of the chunk and returns the value it
+// returns, or the value left on top of the stack if it doesn't return
+func (vm *VM) Run(chunk Chunk) (Value, error) {
+	return vm.script(chunk, nil, cells{})
+}
+
+// Call runs the procedure with the given name of the chunk procedure table
+func (vm *VM) Call(chunk Chunk, name string, args ...Value) (Value, error) {
+	fn, ok := chunk.Procedure(name)
+	if !ok {
+		return Value{}, undefinedProcedureErr(0, name)
+	}
+
+	if len(args) != fn.Arity {
+		return Value{}, wrongArgumentCountErr(0, fn, len(args))
+	}
+
+	vm.start(chunk, fn, args)
+	return vm.run()
+}
+
+// Continue runs the chunk like Run, but its locals start with the values the
+// previous script left in them. A REPL uses it to keep its variables alive
+// between inputs
+func (vm *VM) Continue(chunk Chunk) (Value, error) {
+	return vm.script(chunk, vm.locals, vm.cells)
+}
+
+// script runs the top level code of the chunk and keeps its locals and cells
+func (vm *VM) script(chunk Chunk, locals []Value, cells cells) (Value, error) {
+	vm.start(chunk, &Function{Name: "script", Chunk: chunk}, locals)
+	script := vm.frame
+	script.cells = cells
+	defer func() {
+		vm.locals = script.slots
+		vm.cells = script.cells
+	}()
+
+	return vm.run()
+}
+
+func (vm *VM) start(chunk Chunk, fn *Function, args []Value) {
+	vm.procedures = chunk.Procedures()
+	vm.frames = vm.frames[:0]
+	vm.stack = vm.stack[:0]
+	vm.call(fn, args)
+}
+
+func (vm *VM) call(fn *Function, args []Value) {
+	slots := make([]Value, fn.Chunk.LocalsCount())
+	copy(slots, args)
+
+	vm.frame = &frame{function: fn, slots: slots}
+	vm.frames = append(vm.frames, vm.frame)
+}
+
+// ret pops the current frame and reports whether it was the outermost one
+func (vm *VM) ret(v Value) bool {
+	vm.frames = vm.frames[:len(vm.frames)-1]
+	if len(vm.frames) == 0 {
+		vm.frame = nil
+		return true
+	}
+
+	vm.frame = vm.frames[len(vm.frames)-1]
+	vm.push(v)
+	return false
+}
+
+func (vm *VM) chunk() *Chunk {
+	return &vm.frame.function.Chunk
+}
+
+func (vm *VM) line() int {
+	if vm.frame.ip == 0 {
+		return vm.chunk().Line(0)
+	}
+	return vm.chunk().Line(vm.frame.ip - 1)
+}
+
+func (vm *VM) readByte() byte {
+	b := vm.chunk().Read(vm.frame.ip)
+	vm.frame.ip++
+	return b
+}
+
+func (vm *VM) readShort() int {
+	return vm.readOperand(2)
+}
+
+// readOperand reads a big endian operand of the given width
+func (vm *VM) readOperand(width int) int {
+	operand := 0
+	for i := 0; i < width; i++ {
+		operand = operand<<8 | int(vm.readByte())
+	}
+	return operand
+}
+
+func (vm *VM) push(v Value) {
+	vm.stack = append(vm.stack, v)
+}
+
+func (vm *VM) pop() (Value, error) {
+	if len(vm.stack) == 0 {
+		return Value{}, stackUnderflowErr(vm.line())
+	}
+
+	v := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return v, nil
+}
+
+func (vm *VM) peek() (Value, error) {
+	if len(vm.stack) == 0 {
+		return Value{}, stackUnderflowErr(vm.line())
+	}
+	return vm.stack[len(vm.stack)-1], nil
+}
+
+func (vm *VM) binary(op OpCode) error {
+	b, err := vm.pop()
+	if err != nil {
+		return err
+	}
+
+	a, err := vm.pop()
+	if err != nil {
+		return err
+	}
+
+	switch op {
+	case OpAdd:
+		vm.push(a.add(b))
+	case OpMultiply:
+		vm.push(a.multiply(b))
+	case OpEqual:
+		vm.push(boolValue(a.cmp(b) == 0))
+	case OpGreater:
+		vm.push(boolValue(a.cmp(b) > 0))
+	case OpLesser:
+		vm.push(boolValue(a.cmp(b) < 0))
+	}
+
+	return nil
+}
+
+func (vm *VM) slot(width int) (int, error) {
+	slot := vm.readOperand(width)
+	if slot >= len(vm.frame.slots) {
+		return 0, invalidSlotErr(vm.line(), slot)
+	}
+	return slot, nil
+}
+
+func (vm *VM) constant(width int) error {
+	index := vm.readOperand(width)
+	if index >= vm.chunk().ConstantsCount() {
+		return invalidConstantErr(vm.line(), index)
+	}
+
+	vm.push(vm.chunk().Constant(index))
+	return nil
+}
+
+func (vm *VM) cellIndex() (int, error) {
+	v, err := vm.pop()
+	if err != nil {
+		return 0, err
+	}
+
+	index, ok := v.Uint64()
+	if !ok || index >= maxCells {
+		return 0, cellOutOfRangeErr(vm.line(), v)
+	}
+	return int(index), nil
+}
+
+func (vm *VM) setCell() error {
+	v, err := vm.pop()
+	if err != nil {
+		return err
+	}
+
+	index, err := vm.cellIndex()
+	if err != nil {
+		return err
+	}
+
+	vm.frame.cells.set(index, v)
+	return nil
+}
+
+func (vm *VM) getCell() error {
+	index, err := vm.cellIndex()
+	if err != nil {
+		return err
+	}
+
+	vm.push(vm.frame.cells.get(index))
+	return nil
+}
+
+func (vm *VM) callProcedure() error {
+	index := vm.readShort()
+	if index >= len(vm.procedures) {
+		return invalidProcedureErr(vm.line(), index)
+	}
+
+	// Procedures can't call themselves, not even through other procedures, so
+	// no chain of calls holds more frames than the script and every procedure.
+	// Only bytecode that wasn't written by the compiler goes deeper
+	fn := vm.procedures[index]
+	if len(vm.frames) > len(vm.procedures) {
+		return callTooDeepErr(vm.line(), fn)
+	}
+
+	if len(vm.stack) < fn.Arity {
+		return stackUnderflowErr(vm.line())
+	}
+
+	args := make([]Value, fn.Arity)
+	copy(args, vm.stack[len(vm.stack)-fn.Arity:])
+	vm.stack = vm.stack[:len(vm.stack)-fn.Arity]
+
+	vm.call(fn, args)
+	return nil
+}
+
+func (vm *VM) run() (Value, error) {
+	for {
+		if vm.frame.ip >= vm.chunk().InstructionsCount() {
+			// Falling off the end of a function returns whatever is on top of
+			// the stack
+			var v Value
+			if len(vm.stack) > 0 {
+				v, _ = vm.pop()
+			}
+
+			if vm.ret(v) {
+				return v, nil
+			}
+			continue
+		}
+
+		op := OpCode(vm.readByte())
+		switch op {
+		case OpAdd, OpMultiply, OpEqual, OpGreater, OpLesser:
+			if err := vm.binary(op); err != nil {
+				return Value{}, err
+			}
+		case OpNot:
+			v, err := vm.pop()
+			if err != nil {
+				return Value{}, err
+			}
+			vm.push(boolValue(v.isFalse()))
+		case OpPush:
+			vm.push(NewValue(uint64(vm.readByte())))
+		case OpConstant, OpConstantLong:
+			if err := vm.constant(op.Size() - 1); err != nil {
+				return Value{}, err
+			}
+		case OpPop:
+			if _, err := vm.pop(); err != nil {
+				return Value{}, err
+			}
+		case OpJump, OpJumpLong:
+			offset := vm.readOperand(op.Size() - 1)
+			vm.frame.ip += offset
+		case OpLoop, OpLoopLong:
+			offset := vm.readOperand(op.Size() - 1)
+			vm.frame.ip -= offset
+		case OpJumpIfFalse, OpJumpIfFalseLong:
+			offset := vm.readOperand(op.Size() - 1)
+			v, err := vm.peek()
+			if err != nil {
+				return Value{}, err
+			}
+			if v.isFalse() {
+				vm.frame.ip += offset
+			}
+		case OpSet, OpSetLong:
+			slot, err := vm.slot(op.Size() - 1)
+			if err != nil {
+				return Value{}, err
+			}
+			v, err := vm.pop()
+			if err != nil {
+				return Value{}, err
+			}
+			vm.frame.slots[slot] = v
+		case OpGet, OpGetLong:
+			slot, err := vm.slot(op.Size() - 1)
+			if err != nil {
+				return Value{}, err
+			}
+			vm.push(vm.frame.slots[slot])
+		case OpSetCell:
+			if err := vm.setCell(); err != nil {
+				return Value{}, err
+			}
+		case OpGetCell:
+			if err := vm.getCell(); err != nil {
+				return Value{}, err
+			}
+		case OpCall:
+			if err := vm.callProcedure(); err != nil {
+				return Value{}, err
+			}
+		case OpReturn:
+			v, err := vm.pop()
+			if err != nil {
+				return Value{}, err
+			}
+			if vm.ret(v) {
+				return v, nil
+			}
+		default:
+			return Value{}, unknownInstructionErr(vm.line(), op.Byte())
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package vm_test
+
+import (
+	"github.com/gonzispina/gloop/vm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math"
+	"math/big"
+	"runtime"
+	"testing"
+)
+
+func TestVM_Run(t *testing.T) {
+	t.Run("Arithmetic instructions operate over the top of the stack", func(t *testing.T) {
+		chunk := vm.NewChunk()
+		chunk.Append(vm.OpPush.Byte(), 1, 2)
+		chunk.Append(vm.OpPush.Byte(), 1, 3)
+		chunk.Append(vm.OpPush.Byte(), 1, 4)
+		chunk.Append(vm.OpMultiply.Byte(), 1)
+		chunk.Append(vm.OpAdd.Byte(), 1)
+
+		res, err := vm.New().Run(chunk)
+		require.Nil(t, err)
+		assert.Equal(t, vm.NewValue(14), res)
+	})
+
+	t.Run("Arithmetic promotes numbers that overflow 64 bits", func(t *testing.T) {
+		chunk := vm.NewChunk()
+		max := chunk.AddConstant(vm.NewValue(math.MaxUint64))
+		chunk.Append(vm.OpConstant.Byte(), 1, byte(max))
+		chunk.Append(vm.OpPush.Byte(), 1, 1)
+		chunk.Append(vm.OpAdd.Byte(), 1)
+		chunk.Append(vm.OpConstant.Byte(), 1, byte(max))
+		chunk.Append(vm.OpMultiply.Byte(), 1)
+
+		res, err := vm.New().Run(chunk)
+		require.Nil(t, err)
+
+		expected, _ := new(big.Int).SetString("340282366920938463444927863358058659840", 10)
+		assert.False(t, res.IsSmall())
+		assert.Equal(t, expected.String(), res.String())
+	})
+
+	t.Run("Big numbers are compared by value", func(t *testing.T) {
+		huge, _ := new(big.Int).SetString("1267650600228229401496703205376", 10)
+		chunk := vm.NewChunk()
+		a := chunk.AddConstant(vm.BigValue(huge))
+		b := chunk.AddConstant(vm.NewValue(math.MaxUint64))
+		chunk.Append(vm.OpConstant.Byte(), 1, byte(a))
+		chunk.Append(vm.OpConstant.Byte(), 1, byte(b))
+		chunk.Append(vm.OpGreater.Byte(), 1)
+
+		res, err := vm.New().Run(chunk)
+		require.Nil(t, err)
+		assert.Equal(t, vm.NewValue(1), res)
+	})
+
+	t.Run("Multiplying a big number by zero gives back a small zero", func(t *testing.T) {
+		huge, _ := new(big.Int).SetString("1267650600228229401496703205376", 10)
+		chunk := vm.NewChunk()
+		a := chunk.AddConstant(vm.BigValue(huge))
+		chunk.Append(vm.OpConstant.Byte(), 1, byte(a))
+		chunk.Append(vm.OpPush.Byte(), 1, 0)
+		chunk.Append(vm.OpMultiply.Byte(), 1)
+
+		res, err := vm.New().Run(chunk)
+		require.Nil(t, err)
+		assert.Equal(t, vm.NewValue(0), res)
+	})
+
+	t.Run("Comparison instructions push booleans", func(t *testing.T) {
+		cases := []struct {
+			op       vm.OpCode
+			a, b     byte
+			expected vm.Value
+		}{
+			{vm.OpEqual, 2, 2, vm.NewValue(1)},
+			{vm.OpEqual, 2, 3, vm.NewValue(0)},
+			{vm.OpGreater, 3, 2, vm.NewValue(1)},
+			{vm.OpGreater, 2, 2, vm.NewValue(0)},
+			{vm.OpLesser, 2, 3, vm.NewValue(1)},
+			{vm.OpLesser, 3, 2, vm.NewValue(0)},
+		}
+
+		for _, c := range cases {
+			chunk := vm.NewChunk()
+			chunk.Append(vm.OpPush.Byte(), 1, c.a)
+			chunk.Append(vm.OpPush.Byte(), 1, c.b)
+			chunk.Append(c.op.Byte(), 1)
+
+			res, err := vm.New().Run(chunk)
+			require.Nil(t, err)
+			assert.Equal(t, c.expected, res)
+		}
+	})
+
+	t.Run("Not negates the top of the stack", func(t *testing.T) {
+		chunk := vm.NewChunk()
+		chunk.Append(vm.OpPush.Byte(), 1, 0)
+		chunk.Append(vm.OpNot.Byte(), 1)
+
+		res, err := vm.New().Run(chunk)
+		require.Nil(t, err)
+		assert.Equal(t, vm.NewValue(1), res)
+	})
+
+	t.Run("Locals are stored and loaded through their slots", func(t *testing.T) {
+		chunk := vm.NewChunk()
+		slot := chunk.AddLocal()
+		chunk.Append(vm.OpPush.Byte(), 1, 7)
+		chunk.Append(vm.OpSet.Byte(), 1, byte(slot))
+		chunk.Append(vm.OpGet.Byte(), 2, byte(slot))
+		chunk.Append(vm.OpGet.Byte(), 2, byte(slot))
+		chunk.Append(vm.OpAdd.Byte(), 2)
+
+		res, err := vm.New().Run(chunk)
+		require.Nil(t, err)
+		assert.Equal(t, vm.NewValue(14), res)
+	})
+
+	t.Run("Constants and locals past the first byte use the long encodings", func(t *testing.T) {
+		chunk := vm.NewChunk()
+		for i := 0; i < 300; i++ {
+			chunk.AddConstant(vm.NewValue(uint64(1000 + i)))
+			chunk.AddLocal()
+		}
+
+		require.Nil(t, chunk.EmitConstant(vm.NewValue(1299), 1))
+		require.Nil(t, chunk.EmitLocal(vm.OpSet, 299, 1))
+		require.Nil(t, chunk.EmitLocal(vm.OpGet, 299, 2))
+		require.Nil(t, chunk.EmitConstant(vm.NewValue(1000), 2))
+		require.Nil(t, chunk.EmitLocal(vm.OpSet, 0, 2))
+		require.Nil(t, chunk.EmitLocal(vm.OpGet, 0, 3))
+		chunk.Append(vm.OpAdd.Byte(), 3)
+
+		assert.Equal(t, vm.OpConstantLong.Byte(), chunk.Read(0))
+		assert.Equal(t, vm.OpSetLong.Byte(), chunk.Read(vm.OpConstantLong.Size()))
+
+		res, err := vm.New().Run(chunk)
+		require.Nil(t, err)
+		assert.Equal(t, vm.NewValue(2299), res)
+	})
+
+	t.Run("Constants already in the pool are reused", func(t *testing.T) {
+		huge, _ := new(big.Int).SetString("100000000000000000000", 10)
+		chunk := vm.NewChunk()
+		for i := 0; i < 100000; i++ {
+			chunk.AddConstant(vm.NewValue(uint64(i)))
+		}
+
+		assert.Equal(t, 100000, chunk.AddConstant(vm.BigValue(huge)))
+		assert.Equal(t, 100000, chunk.AddConstant(vm.BigValue(new(big.Int).Set(huge))))
+		assert.Equal(t, 99999, chunk.AddConstant(vm.NewValue(99999)))
+		assert.Equal(t, 100001, chunk.ConstantsCount())
+	})
+
+	t.Run("Jump if false skips the block when the condition is false", func(t *testing.T) {
+		for condition, expected := range map[byte]vm.Value{0: vm.NewValue(2), 1: vm.NewValue(1)} {
+			chunk := vm.NewChunk()
+			slot := chunk.AddLocal()
+			chunk.Append(vm.OpPush.Byte(), 1, 2)
+			chunk.Append(vm.OpSet.Byte(), 1, byte(slot))
+			chunk.Append(vm.OpPush.Byte(), 1, condition)
+			thenJump := chunk.EmitJump(vm.OpJumpIfFalse, 1)
+			chunk.Append(vm.OpPop.Byte(), 2)
+			chunk.Append(vm.OpPush.Byte(), 2, 1)
+			chunk.Append(vm.OpSet.Byte(), 2, byte(slot))
+			elseJump := chunk.EmitJump(vm.OpJump, 2)
+			require.Nil(t, chunk.PatchJump(thenJump))
+			chunk.Append(vm.OpPop.Byte(), 3)
+			require.Nil(t, chunk.PatchJump(elseJump))
+			chunk.Append(vm.OpGet.Byte(), 4, byte(slot))
+
+			res, err := vm.New().Run(chunk)
+			require.Nil(t, err)
+			assert.Equal(t, expected, res)
+		}
+	})
+
+	t.Run("Call runs a procedure in its own frame and return gives back its value", func(t *testing.T) {
+		double := &vm.Function{Name: "DOUBLE", Arity: 1, Chunk: vm.NewChunk()}
+		n := double.Chunk.AddLocal()
+		double.Chunk.Append(vm.OpGet.Byte(), 1, byte(n))
+		double.Chunk.Append(vm.OpGet.Byte(), 1, byte(n))
+		double.Chunk.Append(vm.OpAdd.Byte(), 1)
+		double.Chunk.Append(vm.OpReturn.Byte(), 1)
+
+		chunk := vm.NewChunk()
+		index := chunk.AddProcedure(double)
+		slot := chunk.AddLocal()
+		chunk.Append(vm.OpPush.Byte(), 2, 1)
+		chunk.Append(vm.OpSet.Byte(), 2, byte(slot))
+		chunk.Append(vm.OpPush.Byte(), 3, 21)
+		chunk.Append(vm.OpCall.Byte(), 3, byte(index>>8), byte(index))
+		chunk.Append(vm.OpGet.Byte(), 3, byte(slot))
+		chunk.Append(vm.OpAdd.Byte(), 3)
+		chunk.Append(vm.OpReturn.Byte(), 3)
+
+		res, err := vm.New().Run(chunk)
+		require.Nil(t, err)
+		assert.Equal(t, vm.NewValue(43), res)
+
+		res, err = vm.New().Call(chunk, "DOUBLE", vm.NewValue(4))
+		require.Nil(t, err)
+		assert.Equal(t, vm.NewValue(8), res)
+	})
+
+	t.Run("Calling a procedure with the wrong amount of arguments returns an error", func(t *testing.T) {
+		chunk := vm.NewChunk()
+		chunk.AddProcedure(&vm.Function{Name: "ID", Arity: 1, Chunk: vm.NewChunk()})
+
+		_, err := vm.New().Call(chunk, "ID")
+		assertErrCode(t, err, vm.WrongArgumentCountErrCode)
+
+		_, err = vm.New().Call(chunk, "MISSING")
+		assertErrCode(t, err, vm.UndefinedProcedureErrCode)
+	})
+
+	t.Run("Loop jumps backwards", func(t *testing.T) {
+		chunk := vm.NewChunk()
+		n := chunk.AddLocal()
+		chunk.Append(vm.OpPush.Byte(), 1, 0)
+		chunk.Append(vm.OpSet.Byte(), 1, byte(n))
+		start := chunk.InstructionsCount()
+		chunk.Append(vm.OpGet.Byte(), 2, byte(n))
+		chunk.Append(vm.OpPush.Byte(), 2, 5)
+		chunk.Append(vm.OpLesser.Byte(), 2)
+		exit := chunk.EmitJump(vm.OpJumpIfFalse, 2)
+		chunk.Append(vm.OpPop.Byte(), 2)
+		chunk.Append(vm.OpGet.Byte(), 3, byte(n))
+		chunk.Append(vm.OpPush.Byte(), 3, 1)
+		chunk.Append(vm.OpAdd.Byte(), 3)
+		chunk.Append(vm.OpSet.Byte(), 3, byte(n))
+		require.Nil(t, chunk.EmitLoop(start, 4))
+		require.Nil(t, chunk.PatchJump(exit))
+		chunk.Append(vm.OpPop.Byte(), 4)
+		chunk.Append(vm.OpGet.Byte(), 5, byte(n))
+
+		res, err := vm.New().Run(chunk)
+		require.Nil(t, err)
+		assert.Equal(t, vm.NewValue(5), res)
+	})
+
+	t.Run("Jumps use the whole 16 bit range", func(t *testing.T) {
+		chunk := vm.NewChunk()
+		jump := chunk.EmitJump(vm.OpJump, 1)
+		for i := 0; i < 0xffff; i++ {
+			chunk.Append(vm.OpPop.Byte(), 2)
+		}
+		require.Nil(t, chunk.PatchJump(jump))
+
+		chunk.Append(vm.OpPop.Byte(), 2)
+		assert.NotNil(t, chunk.PatchJump(jump))
+	})
+
+	t.Run("Long jumps cover blocks larger than 64 KiB", func(t *testing.T) {
+		chunk := vm.NewChunk()
+		chunk.UseLongJumps()
+		chunk.Append(vm.OpPush.Byte(), 1, 0)
+		jump := chunk.EmitJump(vm.OpJumpIfFalse, 1)
+		for i := 0; i < 0x10000; i++ {
+			chunk.Append(vm.OpPop.Byte(), 2)
+		}
+		require.Nil(t, chunk.PatchJump(jump))
+		chunk.Append(vm.OpPush.Byte(), 3, 7)
+
+		assert.Equal(t, vm.OpJumpIfFalseLong.Byte(), chunk.Read(jump-1))
+
+		res, err := vm.New().Run(chunk)
+		require.Nil(t, err)
+		assert.Equal(t, vm.NewValue(7), res)
+	})
+
+	t.Run("Cells grow on demand and default to zero", func(t *testing.T) {
+		chunk := vm.NewChunk()
+		chunk.Append(vm.OpPush.Byte(), 1, 200)
+		chunk.Append(vm.OpPush.Byte(), 1, 9)
+		chunk.Append(vm.OpSetCell.Byte(), 1)
+		chunk.Append(vm.OpPush.Byte(), 2, 200)
+		chunk.Append(vm.OpGetCell.Byte(), 2)
+		chunk.Append(vm.OpPush.Byte(), 2, 3)
+		chunk.Append(vm.OpGetCell.Byte(), 2)
+		chunk.Append(vm.OpAdd.Byte(), 2)
+
+		res, err := vm.New().Run(chunk)
+		require.Nil(t, err)
+		assert.Equal(t, vm.NewValue(9), res)
+	})
+
+	t.Run("High cell indexes only cost the cells written", func(t *testing.T) {
+		chunk := vm.NewChunk()
+		index := chunk.AddConstant(vm.NewValue(1<<24 - 1))
+		for i := 0; i < 20; i++ {
+			chunk.Append(vm.OpConstant.Byte(), 1, byte(index))
+			chunk.Append(vm.OpPush.Byte(), 1, byte(i))
+			chunk.Append(vm.OpSetCell.Byte(), 1)
+		}
+		chunk.Append(vm.OpConstant.Byte(), 2, byte(index))
+		chunk.Append(vm.OpGetCell.Byte(), 2)
+		chunk.Append(vm.OpPush.Byte(), 2, 7)
+		chunk.Append(vm.OpGetCell.Byte(), 2)
+		chunk.Append(vm.OpAdd.Byte(), 2)
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		res, err := vm.New().Run(chunk)
+		runtime.ReadMemStats(&after)
+
+		require.Nil(t, err)
+		assert.Equal(t, vm.NewValue(19), res)
+		assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<20))
+	})
+
+	t.Run("Huge cell indexes return a cell out of range error", func(t *testing.T) {
+		chunk := vm.NewChunk()
+		index := chunk.AddConstant(vm.NewValue(math.MaxUint64))
+		chunk.Append(vm.OpConstant.Byte(), 1, byte(index))
+		chunk.Append(vm.OpGetCell.Byte(), 1)
+
+		_, err := vm.New().Run(chunk)
+		assertErrCode(t, err, vm.CellOutOfRangeErrCode)
+	})
+
+	t.Run("Popping an empty stack returns a stack underflow error", func(t *testing.T) {
+		chunk := vm.NewChunk()
+		chunk.Append(vm.OpPop.Byte(), 1)
+
+		_, err := vm.New().Run(chunk)
+		assertErrCode(t, err, vm.StackUnderflowErrCode)
+	})
+
+	t.Run("Reading an undeclared slot returns an invalid slot error", func(t *testing.T) {
+		chunk := vm.NewChunk()
+		chunk.Append(vm.OpGet.Byte(), 1, 3)
+
+		_, err := vm.New().Run(chunk)
+		assertErrCode(t, err, vm.InvalidSlotErrCode)
+	})
+}
+
+func assertErrCode(t *testing.T, err error, code vm.ErrCode) {
+	require.NotNil(t, err)
+	runtimeErr, ok := err.(*vm.RuntimeError)
+	require.True(t, ok)
+	assert.Equal(t, code, runtimeErr.Code)
+}